@@ -0,0 +1,109 @@
+package linux_installer
+
+import (
+	"io/ioutil"
+	"log/slog"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Answers are all the choices made during an installation. They can be recorded from
+// the GUI into an answer file with "-record-answers", and replayed in commandline mode
+// with "-answers", in order to repeat the same installation on other machines.
+//
+// Product and Version denote the installer the answers were recorded with.
+type Answers struct {
+	Product         string   `yaml:"product"`
+	Version         string   `yaml:"version"`
+	Language        string   `yaml:"language"`
+	Target          string   `yaml:"target"`
+	Update          bool     `yaml:"update"`
+	AcceptLicense   bool     `yaml:"accept_license"`
+	InstallType     string   `yaml:"install_type,omitempty"`
+	Components      []string `yaml:"components,omitempty"`
+	Launcher        bool     `yaml:"launcher"`
+	DesktopShortcut bool     `yaml:"desktop_shortcut"`
+	RunInstalled    bool     `yaml:"run_installed"`
+	AllUsers        bool     `yaml:"all_users,omitempty"`
+}
+
+// Answers returns the choices made for the installer so far, together with the
+// installation language and whether the installed application is run afterwards.
+func (i *Installer) Answers(language string, runInstalled bool) *Answers {
+	answers := &Answers{
+		Product:         i.config.Variables["product"],
+		Version:         i.config.Variables["version"],
+		Language:        language,
+		Target:          i.Target,
+		Update:          i.Updating(),
+		AcceptLicense:   i.config.MustAcceptLicense,
+		InstallType:     i.installTypeId(),
+		Launcher:        i.CreateLauncher && i.LauncherAvailable(),
+		DesktopShortcut: i.CreateDesktopShortcut && i.LauncherAvailable(),
+		RunInstalled:    runInstalled,
+		AllUsers:        i.Elevate,
+	}
+	if len(i.config.Components) > 0 {
+		answers.Components = i.SelectedComponents()
+	}
+	return answers
+}
+
+// Save writes the answers into an answer file.
+func (a *Answers) Save(filename string) error {
+	content, err := yaml.Marshal(a)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filename, content, 0644)
+	if err == nil {
+		slog.Info("Recorded answers", "path", filename)
+	}
+	return err
+}
+
+// LoadAnswers reads an answer file for the product in the config. Unknown keys, a
+// missing target, or answers recorded for a different product are errors. Options
+// which are missing from the file keep their default value.
+func LoadAnswers(filename string, config *Config) (*Answers, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		slog.Error("Unable to read answer file", "path", filename, ErrorAttr(err))
+		return nil, errAnswersInvalid
+	}
+	answers := &Answers{Update: true, Launcher: true}
+	err = yaml.UnmarshalStrict(content, answers)
+	if err != nil {
+		slog.Error("Unable to parse answer file", "path", filename, ErrorAttr(err))
+		return nil, errAnswersInvalid
+	}
+	if answers.Product != config.Variables["product"] {
+		slog.Error(
+			"Answer file is for another product", "path", filename,
+			"product", answers.Product, "expected", config.Variables["product"],
+		)
+		return nil, errAnswersProduct
+	}
+	if len(answers.Target) == 0 {
+		slog.Error("Answer file has no target", "path", filename)
+		return nil, errAnswersNoTarget
+	}
+	return answers, nil
+}
+
+// VersionMatches returns whether the answers were recorded with the same version of the
+// product that the config describes.
+func (a *Answers) VersionMatches(config *Config) bool {
+	return a.Version == config.Variables["version"]
+}
+
+// apply sets the commandline config options according to the answers.
+func (a *Answers) apply(config *Config) {
+	config.NoLauncher = !a.Launcher
+	config.DesktopShortcut = a.DesktopShortcut
+	config.RunInstalled = a.RunInstalled
+	config.NoUpdate = !a.Update
+	config.ComponentSelection = a.Components
+	config.InstallTypeSelection = a.InstallType
+	config.AllUsers = a.AllUsers
+}
@@ -0,0 +1,101 @@
+package linux_installer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadAnswers(t *testing.T) {
+	config := &Config{Variables: VariableMap{"product": "Example", "version": "1.0"}}
+	tests := []struct {
+		name    string
+		content string
+		want    *Answers
+		wantErr error
+	}{
+		{
+			"minimal", "product: Example\ntarget: /opt/example\n",
+			&Answers{
+				Product: "Example", Target: "/opt/example",
+				Update: true, Launcher: true,
+			},
+			nil,
+		},
+		{
+			"full",
+			"product: Example\nversion: \"1.0\"\nlanguage: de\ntarget: /opt/example\n" +
+				"update: false\naccept_license: true\ninstall_type: full\n" +
+				"components: [app, docs]\nlauncher: false\ndesktop_shortcut: true\n" +
+				"run_installed: true\nall_users: true\n",
+			&Answers{
+				Product: "Example", Version: "1.0", Language: "de",
+				Target: "/opt/example", AcceptLicense: true, InstallType: "full",
+				Components: []string{"app", "docs"}, DesktopShortcut: true,
+				RunInstalled: true, AllUsers: true,
+			},
+			nil,
+		},
+		{
+			"unknown key", "product: Example\ntarget: /opt/example\nlaunch: false\n",
+			nil, errAnswersInvalid,
+		},
+		{
+			"wrong type", "product: Example\ntarget: /opt/example\nupdate: sometimes\n",
+			nil, errAnswersInvalid,
+		},
+		{
+			"duplicate key", "product: Example\ntarget: /a\ntarget: /b\n",
+			nil, errAnswersInvalid,
+		},
+		{"not yaml", "product: [Example\n", nil, errAnswersInvalid},
+		{
+			"other product", "product: Other\ntarget: /opt/example\n",
+			nil, errAnswersProduct,
+		},
+		{"no product", "target: /opt/example\n", nil, errAnswersProduct},
+		{"no target", "product: Example\n", nil, errAnswersNoTarget},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "answers.yml")
+			if err := os.WriteFile(filename, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			answers, err := LoadAnswers(filename, config)
+			if err != test.wantErr {
+				t.Fatalf("error %v, want %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(answers, test.want) {
+				t.Errorf("answers %+v, want %+v", answers, test.want)
+			}
+		})
+	}
+	_, err := LoadAnswers(filepath.Join(t.TempDir(), "missing.yml"), config)
+	if err != errAnswersInvalid {
+		t.Errorf("missing file: error %v, want %v", err, errAnswersInvalid)
+	}
+}
+
+func TestAnswersSaveLoad(t *testing.T) {
+	config := &Config{Variables: VariableMap{"product": "Example", "version": "1.0"}}
+	answers := &Answers{
+		Product: "Example", Version: "1.0", Language: "en", Target: "/opt/example",
+		Components: []string{"app"}, Launcher: true, AllUsers: true,
+	}
+	filename := filepath.Join(t.TempDir(), "answers.yml")
+	if err := answers.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadAnswers(filename, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, answers) {
+		t.Errorf("loaded %+v, want %+v", loaded, answers)
+	}
+	if !loaded.VersionMatches(config) {
+		t.Error("version doesn't match")
+	}
+}
@@ -0,0 +1,248 @@
+package linux_installer
+
+import (
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// matches returns whether the payload file with the given name belongs to the
+// component, i.e. whether one of the component's path patterns matches the file itself
+// or one of its parent directories.
+func (c *Component) matches(name string) bool {
+	name = path.Clean(strings.TrimSuffix(name, "/"))
+	for _, pattern := range c.Paths {
+		pattern = path.Clean(strings.TrimSuffix(pattern, "/"))
+		for p := name; p != "." && p != "/"; p = path.Dir(p) {
+			if matched, _ := path.Match(pattern, p); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Components returns the list of all components defined in the config.
+func (i *Installer) Components() []Component {
+	return i.config.Components
+}
+
+// component returns the component with the given ID, or nil if there is none.
+func (i *Installer) component(id string) *Component {
+	for c := range i.config.Components {
+		if i.config.Components[c].Id == id {
+			return &i.config.Components[c]
+		}
+	}
+	return nil
+}
+
+// ComponentSelected returns whether the component with the given ID will be installed.
+func (i *Installer) ComponentSelected(id string) bool {
+	return i.selectedComponents[id]
+}
+
+// SelectedComponents returns the IDs of all selected components, in the order of the
+// config.
+func (i *Installer) SelectedComponents() (ids []string) {
+	for _, c := range i.config.Components {
+		if i.selectedComponents[c.Id] {
+			ids = append(ids, c.Id)
+		}
+	}
+	return
+}
+
+// SelectComponent selects or deselects a single component for installation. Selecting
+// a component also selects all components it depends on, deselecting it also deselects
+// all components that depend on it. Required components (and components that required
+// components depend on) cannot be deselected, which is silently ignored.
+//
+// Returns an error if there is no component with the given ID.
+func (i *Installer) SelectComponent(id string, selected bool) error {
+	if i.component(id) == nil {
+		return errComponentUnknown
+	}
+	if selected {
+		i.selectComponent(id)
+	} else if !i.ComponentRequired(id) {
+		i.deselectComponent(id)
+	}
+	i.filterFiles()
+	return nil
+}
+
+// SetComponents replaces the current component selection with the components given by
+// ids (and their dependencies). Required components are always selected. Returns an
+// error if any of the IDs is unknown, in which case the selection is unchanged.
+func (i *Installer) SetComponents(ids []string) error {
+	for _, id := range ids {
+		if i.component(id) == nil {
+			slog.Error("Unknown component", "component", id)
+			return errComponentUnknown
+		}
+	}
+	i.selectedComponents = make(map[string]bool)
+	for _, c := range i.config.Components {
+		if c.Required {
+			i.selectComponent(c.Id)
+		}
+	}
+	for _, id := range ids {
+		i.selectComponent(id)
+	}
+	i.filterFiles()
+	return nil
+}
+
+// resetComponents selects the default and required components.
+func (i *Installer) resetComponents() {
+	i.selectedComponents = make(map[string]bool)
+	for _, c := range i.config.Components {
+		if c.Default || c.Required {
+			i.selectComponent(c.Id)
+		}
+	}
+}
+
+// selectComponent marks a component and, recursively, its dependencies as selected.
+func (i *Installer) selectComponent(id string) {
+	c := i.component(id)
+	if c == nil {
+		slog.Warn("Unknown component dependency", "component", id)
+		return
+	}
+	if i.selectedComponents[id] {
+		return
+	}
+	i.selectedComponents[id] = true
+	for _, dependency := range c.Depends {
+		i.selectComponent(dependency)
+	}
+}
+
+// deselectComponent unmarks a component and, recursively, all components depending on
+// it.
+func (i *Installer) deselectComponent(id string) {
+	if !i.selectedComponents[id] {
+		return
+	}
+	delete(i.selectedComponents, id)
+	for _, c := range i.config.Components {
+		for _, dependency := range c.Depends {
+			if dependency == id {
+				i.deselectComponent(c.Id)
+			}
+		}
+	}
+}
+
+// ComponentRequired returns whether a component is required, or whether any required
+// component depends on it (directly or indirectly). Such a component cannot be
+// deselected.
+func (i *Installer) ComponentRequired(id string) bool {
+	return i.componentRequiredVisited(id, map[string]bool{})
+}
+
+// componentRequiredVisited is the recursive part of ComponentRequired, keeping track of
+// the visited components to guard against dependency cycles.
+func (i *Installer) componentRequiredVisited(id string, visited map[string]bool) bool {
+	if visited[id] {
+		return false
+	}
+	visited[id] = true
+	if c := i.component(id); c != nil && c.Required {
+		return true
+	}
+	for _, c := range i.config.Components {
+		for _, dependency := range c.Depends {
+			if dependency == id && i.componentRequiredVisited(c.Id, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fileComponents returns the IDs of all components which contain the given file.
+func (i *Installer) fileComponents(file *InstallFile) (ids []string) {
+	for c := range i.config.Components {
+		if i.config.Components[c].matches(file.Name) {
+			ids = append(ids, i.config.Components[c].Id)
+		}
+	}
+	return
+}
+
+// fileSelected returns whether a single payload file is part of the selection, based on
+// its own components only. A file without any components is always selected.
+func (i *Installer) fileSelected(file *InstallFile) bool {
+	ids := i.fileComponents(file)
+	if len(ids) == 0 {
+		return true
+	}
+	for _, id := range ids {
+		if i.selectedComponents[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// filterFiles sets the list of files to be installed from all payload files, according
+// to the current component selection, and updates the total size.
+//
+// Files are selected if they belong to any selected component, or to no component at
+// all. Directories are selected likewise, but additionally a directory is created if
+// any of its contents is selected, and skipped if all of its contents are deselected.
+func (i *Installer) filterFiles() {
+	if !i.dataPrepared {
+		return
+	}
+	parentsAll := make(map[string]bool)
+	parentsSelected := make(map[string]bool)
+	for _, file := range i.allFiles {
+		if file.IsDir() {
+			continue
+		}
+		selected := i.fileSelected(file)
+		for p := path.Dir(file.Name); p != "." && p != "/"; p = path.Dir(p) {
+			parentsAll[p] = true
+			if selected {
+				parentsSelected[p] = true
+			}
+		}
+	}
+	i.files = make([]*InstallFile, 0, len(i.allFiles))
+	i.totalSize = 0
+	for _, file := range i.allFiles {
+		if file.IsDir() {
+			name := path.Clean(file.Name)
+			if !parentsSelected[name] && (parentsAll[name] || !i.fileSelected(file)) {
+				continue
+			}
+		} else if !i.fileSelected(file) {
+			continue
+		}
+		i.files = append(i.files, file)
+		if !file.IsDir() {
+			i.totalSize += int64(file.UncompressedSize64)
+		}
+	}
+}
+
+// ComponentSizeString returns a human-readable string denoting the total size of all
+// files in the component with the given ID.
+func (i *Installer) ComponentSizeString(id string) string {
+	i.prepareDataFiles()
+	c := i.component(id)
+	size := int64(0)
+	if c != nil {
+		for _, file := range i.allFiles {
+			if !file.IsDir() && c.matches(file.Name) {
+				size += int64(file.UncompressedSize64)
+			}
+		}
+	}
+	return sizeString(size)
+}
@@ -0,0 +1,180 @@
+package linux_installer
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// testComponents are components with dependencies on each other, for the tests of the
+// component selection.
+var testComponents = []Component{
+	{Id: "core", Paths: []string{"bin", "lib/*.so"}, Required: true},
+	{Id: "app", Paths: []string{"app"}, Default: true, Depends: []string{"core"}},
+	{Id: "docs", Paths: []string{"docs/"}, Default: true},
+	{Id: "examples", Paths: []string{"docs/examples"}, Depends: []string{"docs"}},
+	{Id: "extras", Paths: []string{"extras/*.txt"}, Depends: []string{"examples"}},
+}
+
+// newComponentsInstaller returns an installer with the testComponents and its default
+// selection, for files with the given names. Names ending in "/" are directories.
+func newComponentsInstaller(names ...string) *Installer {
+	i := &Installer{config: &Config{Components: testComponents}}
+	for _, name := range names {
+		mode := os.FileMode(0644)
+		if name[len(name)-1] == '/' {
+			mode = os.ModeDir | 0755
+		}
+		i.allFiles = append(i.allFiles, &InstallFile{
+			PayloadFile: &PayloadFile{Name: name, UncompressedSize64: 1, mode: mode},
+		})
+	}
+	i.dataPrepared = true
+	i.resetComponents()
+	i.filterFiles()
+	return i
+}
+
+func TestComponentMatches(t *testing.T) {
+	tests := []struct {
+		paths []string
+		name  string
+		want  bool
+	}{
+		{[]string{"docs"}, "docs", true},
+		{[]string{"docs"}, "docs/", true},
+		{[]string{"docs"}, "docs/manual/index.html", true},
+		{[]string{"docs/"}, "docs/index.html", true},
+		{[]string{"docs"}, "documents/index.html", false},
+		{[]string{"docs"}, "app/docs", false},
+		{[]string{"lib/*.so"}, "lib/libfoo.so", true},
+		{[]string{"lib/*.so"}, "lib/libfoo.a", false},
+		{[]string{"lib/*.so"}, "lib/sub/libfoo.so", false},
+		{[]string{"*/examples"}, "docs/examples/hello.c", true},
+		{[]string{"app", "bin/*"}, "bin/tool", true},
+		{[]string{"app", "bin/*"}, "share/tool", false},
+		{nil, "app", false},
+	}
+	for _, test := range tests {
+		c := Component{Paths: test.paths}
+		if got := c.matches(test.name); got != test.want {
+			t.Errorf("%q matches %q: %v", test.paths, test.name, got)
+		}
+	}
+}
+
+func TestSelectComponent(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		selected bool
+		want     []string
+	}{
+		{"defaults", "app", true, []string{"core", "app", "docs"}},
+		{"with dependencies", "extras", true,
+			[]string{"core", "app", "docs", "examples", "extras"}},
+		{"without dependents", "docs", false, []string{"core", "app"}},
+		{"required", "core", false, []string{"core", "app", "docs"}},
+		{"dependency of required", "app", false, []string{"core", "docs"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newComponentsInstaller()
+			i.SelectComponent("extras", true)
+			i.SelectComponent("examples", false)
+			if err := i.SelectComponent(test.id, test.selected); err != nil {
+				t.Fatal(err)
+			}
+			if got := i.SelectedComponents(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("selected %v, want %v", got, test.want)
+			}
+		})
+	}
+	if err := newComponentsInstaller().SelectComponent("none", true); err == nil {
+		t.Error("selecting an unknown component succeeded")
+	}
+}
+
+func TestSetComponents(t *testing.T) {
+	tests := []struct {
+		ids     []string
+		want    []string
+		wantErr bool
+	}{
+		{nil, []string{"core"}, false},
+		{[]string{"docs"}, []string{"core", "docs"}, false},
+		{[]string{"extras"}, []string{"core", "docs", "examples", "extras"}, false},
+		{[]string{"app", "none"}, []string{"core", "app", "docs"}, true},
+	}
+	for _, test := range tests {
+		i := newComponentsInstaller()
+		err := i.SetComponents(test.ids)
+		if (err != nil) != test.wantErr {
+			t.Errorf("SetComponents(%v) error = %v", test.ids, err)
+		}
+		if got := i.SelectedComponents(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SetComponents(%v) selected %v, want %v", test.ids, got, test.want)
+		}
+	}
+}
+
+func TestComponentRequiredCycle(t *testing.T) {
+	i := &Installer{config: &Config{Components: []Component{
+		{Id: "a", Depends: []string{"b"}},
+		{Id: "b", Depends: []string{"a"}},
+	}}}
+	if i.ComponentRequired("a") {
+		t.Error("component in a cycle without required components is required")
+	}
+}
+
+func TestFilterFiles(t *testing.T) {
+	names := []string{
+		"bin/", "bin/tool", "app/", "app/main", "docs/", "docs/index.html",
+		"docs/examples/", "docs/examples/hello.c", "extras/", "extras/a.txt",
+		"extras/b.bin", "empty/", "README",
+	}
+	tests := []struct {
+		name       string
+		components []string
+		want       []string
+	}{
+		{"defaults", []string{"app", "docs"}, []string{
+			"bin/", "bin/tool", "app/", "app/main", "docs/", "docs/index.html",
+			"docs/examples/", "docs/examples/hello.c", "extras/", "extras/b.bin",
+			"empty/", "README",
+		}},
+		{"with dependency", []string{"examples"}, []string{
+			"bin/", "bin/tool", "docs/", "docs/index.html", "docs/examples/",
+			"docs/examples/hello.c", "extras/", "extras/b.bin", "empty/", "README",
+		}},
+		{"required only", nil, []string{
+			"bin/", "bin/tool", "extras/", "extras/b.bin", "empty/", "README",
+		}},
+		{"all", []string{"app", "extras"}, names},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newComponentsInstaller(names...)
+			if err := i.SetComponents(test.components); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, file := range i.files {
+				got = append(got, file.Name)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("files %v, want %v", got, test.want)
+			}
+			size := int64(0)
+			for _, name := range test.want {
+				if name[len(name)-1] != '/' {
+					size++
+				}
+			}
+			if i.totalSize != size {
+				t.Errorf("total size %d, want %d", i.totalSize, size)
+			}
+		})
+	}
+}
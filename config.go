@@ -1,7 +1,7 @@
 package linux_installer
 
 import (
-	"log"
+	"log/slog"
 
 	"gopkg.in/yaml.v2"
 )
@@ -17,20 +17,178 @@ const configFilename = "config.yml"
 // DefaultInstallDirName is a string or template for the default application directory,
 // into which to install.
 //
+// Components is a list of optional parts of the payload which can be selected or
+// deselected for installation. See Component for details.
+//
+// InstallTypes is a list of installation types to choose from. The first one is the
+// default. See InstallType for details.
+//
+// Requirements are conditions the system has to meet for the installation. See
+// Requirements for details.
+//
+// InstallWorkers is the number of files that are installed at the same time. If it is
+// 0, the number of CPUs is used, up to 8.
+//
+// StagedInstall installs the files into a hidden directory next to the target first, and
+// moves it into place only once all files are written and verified. Updates replace the
+// previous installation atomically.
+//
+// NoVerify skips reading back all written files to check them against the payload
+// after the installation, see Installer.verifyFiles. It saves time for large payloads,
+// but a damaged file then goes unnoticed.
+//
+// LogFilename is the name of the installer's log file, in $XDG_STATE_HOME/<product>/
+// (usually ~/.local/state/<product>/). An absolute path is used as is.
+//
+// LogFormat is the format of the log file, "text" (the default) for key=value pairs or
+// "json" for one JSON object per line.
+//
 // NoLauncher is a flag from the command line that suppresses launcher shortcut
 // creation.
 //
+// DesktopShortcut is a flag from the command line that creates a desktop shortcut in
+// addition to the launcher entry.
+//
 // RunInstalled is a flag from the command line that runs the installed application
 // after installation completes successfully.
+//
+// ComponentSelection is a list of component IDs from the command line. If it is nil, the
+// default components are installed.
+//
+// InstallTypeSelection is an installation type ID from the command line. If it is empty,
+// the default type is used.
+//
+// NoUpdate is set from an answer file, and installs normally into the directory of a
+// previous installation instead of updating it.
+//
+// Repair is a flag from the command line that repairs the installation in the target
+// instead of installing, see Installer.Repair.
+//
+// NoResume is a flag from the command line that cleans up an unfinished installation in
+// the target instead of resuming it.
+//
+// RecordAnswersFile is a path from the command line, to which the choices made in the
+// GUI are written after a successful installation. See Answers.
+//
+// ProgressFormat is the output format for the progress of a commandline installation,
+// either "text" for humans or "json" for one JSON object per line.
+//
+// LogPath is the path of the log file in use, from the -log flag or LogFilename. The log
+// is copied into the installation directory after a successful installation, from
+// LogStart on, i.e. without the records of earlier runs.
+//
+// AllUsers is a flag from the command line that installs for all users, by running the
+// installer with administrator rights through pkexec or sudo, see InstallElevated().
+//
+// Elevated is set from an internal flag in the installer that InstallElevated() runs
+// with administrator rights. It aborts the installation once its stdin is closed.
 type Config struct {
-	Variables             VariableMap `yaml:"variables,omitempty"`
-	MustAcceptLicense     bool        `yaml:"must_accept_license"`
-	DefaultInstallDirName string      `yaml:"default_install_dir_name"`
-	GuiCss                string      `yaml:"gui_css,omitempty"`
+	Variables             VariableMap   `yaml:"variables,omitempty"`
+	MustAcceptLicense     bool          `yaml:"must_accept_license"`
+	DefaultInstallDirName string        `yaml:"default_install_dir_name"`
+	GuiCss                string        `yaml:"gui_css,omitempty"`
+	Components            []Component   `yaml:"components,omitempty"`
+	InstallTypes          []InstallType `yaml:"install_types,omitempty"`
+	Requirements          Requirements  `yaml:"requirements,omitempty"`
+	InstallWorkers        int           `yaml:"install_workers,omitempty"`
+	StagedInstall         bool          `yaml:"staged_install,omitempty"`
+	NoVerify              bool          `yaml:"no_verify,omitempty"`
+	LogFilename           string        `yaml:"log_filename,omitempty"`
+	LogFormat             string        `yaml:"log_format,omitempty"`
 
 	// commandline config options
-	NoLauncher   bool
-	RunInstalled bool
+	NoLauncher           bool
+	DesktopShortcut      bool
+	RunInstalled         bool
+	ComponentSelection   []string
+	InstallTypeSelection string
+	NoUpdate             bool
+	Repair               bool
+	NoResume             bool
+	RecordAnswersFile    string
+	ProgressFormat       string
+	LogPath              string
+	LogStart             int64
+	AllUsers             bool
+	Elevated             bool
+}
+
+// Component is a named part of the installation payload that the user may choose to
+// install or not.
+//
+// Title and Description are shown in the GUI and may contain template references to
+// language strings, e.g. "{{.component_docs}}".
+//
+// Paths is a list of glob patterns (see path.Match) for files in the data payload. A
+// pattern matches a file if it matches the file itself or any of its parent
+// directories, so "docs" selects the whole docs directory. Payload files not matched by
+// any component are always installed.
+//
+// Default components are selected initially, Required components cannot be deselected.
+// Depends lists the IDs of components which are selected along with this one.
+type Component struct {
+	Id          string   `yaml:"id"`
+	Title       string   `yaml:"title"`
+	Description string   `yaml:"description,omitempty"`
+	Paths       []string `yaml:"paths"`
+	Default     bool     `yaml:"default"`
+	Required    bool     `yaml:"required"`
+	Depends     []string `yaml:"depends,omitempty"`
+}
+
+// InstallType is a kind of installation that the user can choose, e.g. a client or a
+// server installation. The ID of the chosen type is available as the "installType"
+// template variable.
+//
+// Label and Description are shown in the GUI and may contain template references to
+// language strings, e.g. "{{.type_normal}}".
+//
+// Components is a list of component IDs that are selected when choosing this type. If
+// it is empty, the default components are selected.
+//
+// Hooks is a list of hook names (e.g. "pre-install") that run for this type. If it is
+// empty, all hooks run.
+//
+// Launcher can be set to false in order to not create a launcher entry for this type.
+type InstallType struct {
+	Id          string   `yaml:"id"`
+	Label       string   `yaml:"label"`
+	Description string   `yaml:"description,omitempty"`
+	Components  []string `yaml:"components,omitempty"`
+	Hooks       []string `yaml:"hooks,omitempty"`
+	Launcher    *bool    `yaml:"launcher,omitempty"`
+}
+
+// Requirements are conditions the system has to meet for the installation. They are
+// checked before the path screen in the GUI, and before a commandline installation.
+// Empty requirements are not checked.
+//
+// Arch is a list of supported CPU architectures, as printed by "uname -m", e.g.
+// "x86_64" or "aarch64".
+//
+// MinKernel and MinGlibc are the minimum versions of the kernel and the C library, e.g.
+// "4.15" and "2.27".
+//
+// Libraries is a list of shared libraries that must be installed, by their file name,
+// e.g. "libGL.so.1". Executables is a list of programs that must be on the PATH.
+//
+// MinMemoryMB is the minimum amount of RAM in MiB.
+//
+// FreeSpaceMB is the disk space in MiB that must remain free on the target's disk after
+// the installation. It is checked along with the space for the files.
+//
+// Warn is a list of the keys above (e.g. "min_memory_mb") whose requirements only warn
+// when they aren't met, instead of preventing the installation. FreeSpaceMB always
+// prevents it.
+type Requirements struct {
+	Arch        []string `yaml:"arch,omitempty"`
+	MinKernel   string   `yaml:"min_kernel,omitempty"`
+	MinGlibc    string   `yaml:"min_glibc,omitempty"`
+	Libraries   []string `yaml:"libraries,omitempty"`
+	Executables []string `yaml:"executables,omitempty"`
+	MinMemoryMB int64    `yaml:"min_memory_mb,omitempty"`
+	FreeSpaceMB int64    `yaml:"free_space_mb,omitempty"`
+	Warn        []string `yaml:"warn,omitempty"`
 }
 
 // NewConfig returns a Config object containing the settings from resources/config.yml.
@@ -39,7 +197,9 @@ func NewConfig() (*Config, error) {
 	config := &Config{Variables: make(VariableMap)}
 	err := yaml.Unmarshal([]byte(configFile), config)
 	if err != nil {
-		log.Printf("Unable to parse config file %s\n", configFilename)
+		slog.Error(
+			"Unable to parse config file", "path", configFilename, ErrorAttr(err),
+		)
 		return config, err
 	}
 	return config, err
@@ -0,0 +1,294 @@
+package linux_installer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// elevatedAnswersFilename is the name of the answer file in the installer's
+	// temporary directory, with which the choices are passed on to the elevated
+	// installer.
+	elevatedAnswersFilename = "elevated-answers.yml"
+	// polkitPolicyFilename is the resource file with the template for the polkit
+	// action, see PolkitPolicy().
+	polkitPolicyFilename = "polkit/linux-installer.policy"
+	// elevatedFlag is the internal commandline flag, with which the installer runs
+	// itself with administrator rights, see InstallElevated().
+	elevatedFlag = "elevated"
+)
+
+// errNoElevationCommand is the reason for ErrElevationFailed if neither pkexec nor sudo
+// are available.
+var errNoElevationCommand = errors.New("no command to gain administrator rights found")
+
+// CanElevate returns whether the installer can install for all users, by running a
+// copy of itself with administrator rights (see InstallElevated()). That is not the
+// case if it already runs with them. With gui, the password has to be asked for
+// graphically, since there may be no terminal.
+func CanElevate(gui bool) bool {
+	return !osIsRoot() && osElevationCommand(gui) != nil // os-specific
+}
+
+// AllUsersInstallDir returns the directory in which applications are usually installed
+// for all users, e.g. /opt.
+func AllUsersInstallDir() string { return osAllUsersInstallDir() } // os-specific
+
+// InstallElevated runs the whole installation, from the pre-install to the post-install
+// hook, in a copy of the installer with administrator rights, through pkexec or sudo.
+// The choices made for this installer are passed on in an answer file, and the elevated
+// installer's progress is emitted as events of this one, as from InstallContext(). With
+// gui, the password is asked for graphically (see CanElevate()).
+//
+// The elevated installer doesn't touch any of the user's files: its log is merged into
+// this installer's log (see mergeElevatedLog()), and the desktop shortcut is created by
+// this installer once the installation succeeded, with the given variables as in
+// PostInstall().
+//
+// Canceling ctx makes the elevated installer abort and roll back the installation, and
+// returns ErrAborted. If the elevated installer ends without a result, e.g. because
+// the password wasn't given, ErrElevationFailed is returned.
+func (i *Installer) InstallElevated(
+	ctx context.Context, gui bool, variablesList ...VariableMap,
+) error {
+	i.actionLock.Lock()
+	defer i.actionLock.Unlock()
+	finished, err := i.runElevated(ctx, gui, i.variables(variablesList...))
+	if !finished {
+		err = ErrElevationFailed.wrap(err)
+		slog.Error("Unable to install for all users", ErrorAttr(err))
+		i.setStatus(InstallStatus{Done: true, Phase: PhaseFailed, Err: err})
+	}
+	i.err = err
+	i.setDone(true)
+	return err
+}
+
+// runElevated runs the elevated installer for InstallElevated(), and emits its events.
+// Returns whether it finished, with the error of the installation, or else the reason
+// why it didn't.
+func (i *Installer) runElevated(
+	ctx context.Context, gui bool, variables VariableMap,
+) (bool, error) {
+	elevation := osElevationCommand(gui) // os-specific
+	if elevation == nil {
+		return false, errNoElevationCommand
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return false, err
+	}
+	answersFile := filepath.Join(i.tempPath, elevatedAnswersFilename)
+	answers := i.Answers(i.Language, false)
+	answers.DesktopShortcut = false // created on the user's desktop by this installer
+	err = answers.Save(answersFile)
+	if err != nil {
+		return false, err
+	}
+	args := append(elevation[1:], executable, "-"+elevatedFlag, "-answers", answersFile)
+	args = append(args, "-progress", progressFormatJson)
+	if len(i.config.LogFormat) > 0 {
+		args = append(args, "-log-format", i.config.LogFormat)
+	}
+	if !i.Resume {
+		args = append(args, "-no-resume")
+	}
+	if i.Repair {
+		args = append(args, "-repair", i.Target)
+	}
+	cmd := exec.Command(elevation[0], args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return false, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return false, err
+	}
+	slog.Info("Running installer for all users", "cmd", cmd.String())
+	err = cmd.Start()
+	if err != nil {
+		return false, err
+	}
+	logged := make(chan struct{})
+	go func() {
+		mergeElevatedLog(stderr)
+		close(logged)
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			slog.Info("Aborting installation for all users")
+			stdin.Close()
+		case <-done:
+		}
+	}()
+	finished := false
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var output jsonEvent
+		if json.Unmarshal(scanner.Bytes(), &output) != nil {
+			slog.Debug("Output of installer for all users", "line", scanner.Text())
+			continue
+		}
+		event := output.event()
+		switch event := event.(type) {
+		case FinishedEvent:
+			finished, err = true, event.Err
+			if err == nil && i.CreateDesktopShortcut && i.LauncherAvailable() {
+				i.createDesktopShortcut(variables, event.ByteProgress)
+			}
+		case RolledBackEvent:
+			finished, err = true, ErrAborted
+		}
+		i.emit(event)
+	}
+	<-logged
+	waitErr := cmd.Wait()
+	if !finished {
+		return false, waitErr
+	}
+	return true, err
+}
+
+// createDesktopShortcut creates the desktop shortcut for an installation for all users,
+// as the user who runs this installer, and emits the desktop-shortcut phase with the
+// given progress of the elevated installer. The shortcut isn't part of the installation
+// and isn't removed by its uninstaller, since that runs as root.
+func (i *Installer) createDesktopShortcut(variables VariableMap, progress ByteProgress) {
+	shortcutFile, err := osCreateDesktopShortcut(variables) // os-specific
+	if err != nil {
+		slog.Warn(
+			"Unable to create desktop shortcut", "phase", PhaseDesktopShortcut,
+			ErrorAttr(err),
+		)
+	}
+	i.emit(PhaseEvent{
+		ByteProgress: progress, Phase: PhaseDesktopShortcut, Path: shortcutFile, Err: err,
+	})
+}
+
+// mergeElevatedLog passes the log records of the elevated installer on to this
+// installer's log, marked as "elevated". The elevated installer writes them to stderr
+// as JSON (see openLog()), so that it never has to open a log file the user chose.
+// Other output is passed on to stderr.
+func mergeElevatedLog(stderr io.Reader) {
+	handler := slog.Default().Handler()
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		record, ok := elevatedLogRecord(scanner.Bytes())
+		if !ok {
+			fmt.Fprintln(os.Stderr, scanner.Text())
+			continue
+		}
+		handler.Handle(context.Background(), record)
+	}
+}
+
+// elevatedLogRecord returns the log record for a line of JSON, as written by
+// slog.JSONHandler, with its attributes in their original order. Returns false if the
+// line isn't a log record.
+func elevatedLogRecord(line []byte) (slog.Record, bool) {
+	attrs, ok := jsonAttrs(line)
+	if !ok {
+		return slog.Record{}, false
+	}
+	var timestamp time.Time
+	var level slog.Level
+	var msg string
+	hasLevel, hasMsg := false, false
+	recordAttrs := []slog.Attr{slog.Bool("elevated", true)}
+	for _, attr := range attrs {
+		switch attr.Key {
+		case slog.TimeKey:
+			timestamp, _ = time.Parse(time.RFC3339Nano, attr.Value.String())
+		case slog.LevelKey:
+			hasLevel = level.UnmarshalText([]byte(attr.Value.String())) == nil
+		case slog.MessageKey:
+			msg, hasMsg = attr.Value.String(), true
+		default:
+			recordAttrs = append(recordAttrs, attr)
+		}
+	}
+	if !hasLevel || !hasMsg {
+		return slog.Record{}, false
+	}
+	record := slog.NewRecord(timestamp, level, msg, 0)
+	record.AddAttrs(recordAttrs...)
+	return record, true
+}
+
+// jsonAttrs returns the members of a JSON object as log attributes, in their order.
+// Nested objects become groups. Returns false if object isn't a JSON object.
+func jsonAttrs(object []byte) ([]slog.Attr, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(object))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, false
+	}
+	attrs := []slog.Attr{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, false
+		}
+		key, _ := token.(string)
+		var raw json.RawMessage
+		if decoder.Decode(&raw) != nil {
+			return nil, false
+		}
+		if group, ok := jsonAttrs(raw); ok {
+			attrs = append(attrs, slog.Attr{Key: key, Value: slog.GroupValue(group...)})
+			continue
+		}
+		var value any
+		json.Unmarshal(raw, &value)
+		attrs = append(attrs, slog.Any(key, value))
+	}
+	return attrs, true
+}
+
+// cancelOnStdinClosed returns a context which is canceled once stdin is closed. The
+// installer that runs an elevated one (see InstallElevated()) closes its stdin in order
+// to abort the installation.
+func cancelOnStdinClosed(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		io.Copy(io.Discard, os.Stdin)
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// PolkitPolicy returns a polkit action for running the installer at its current path
+// with pkexec, from the template in the resources. Installed into
+// /usr/share/polkit-1/actions/, it makes the password prompt for installing for all
+// users show the product's own message.
+func PolkitPolicy(translator *Translator) (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	policy, err := GetResource(polkitPolicyFilename)
+	if err != nil {
+		return "", err
+	}
+	return translator.expand(
+		policy, translator.language, VariableMap{"installerPath": executable},
+	), nil
+}
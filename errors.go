@@ -0,0 +1,115 @@
+package linux_installer
+
+import (
+	"errors"
+)
+
+// Exit statuses of the installer process, as returned by Run(). These are stable, so
+// that wrapper scripts can rely on them.
+const (
+	ExitSuccess            = 0  // installed successfully, or GUI closed before installing
+	ExitError              = 1  // any error not covered by the codes below
+	ExitUsage              = 2  // invalid commandline flags or answer file
+	ExitLicenseNotAccepted = 3  // commandline install without -accept
+	ExitGuiFailed          = 4  // GUI could not be started
+	ExitNoLanguages        = 5  // no language files in the installer
+	ExitPathInvalid        = 6  // target is not a directory, or can't be checked
+	ExitPathNotWritable    = 7  // target is not writable
+	ExitNotEnoughSpace     = 8  // not enough disk space for the target
+	ExitPayloadCorrupt     = 9  // installer payload can't be read, or its signature is invalid
+	ExitHookFailed         = 10 // pre- or post-install hook failed
+	ExitAborted            = 11 // installation aborted and rolled back by the user
+	ExitNotInstalled       = 12 // product given to -uninstall or -repair is not installed
+	ExitVerifyFailed       = 13 // installed files don't match the payload
+	ExitRequirementsNotMet = 14 // the system doesn't meet the requirements from the config
+	ExitElevationFailed    = 15 // administrator rights for installing for all users denied
+)
+
+// Error is an installer error. Its message is a translation key, like
+// "path_err_not_writable", and it has an exit status for the installer process.
+//
+// Errors may wrap an underlying error with details, e.g. the output of a failed hook
+// script. Use errors.Is() to compare an error to the Err* variables, which also matches
+// wrapping errors.
+type Error struct {
+	Key  string
+	Exit int
+	Err  error
+}
+
+// Errors that can occur during an installation.
+var (
+	ErrPathInvalid        = &Error{Key: "path_err_not_dir", Exit: ExitPathInvalid}
+	ErrPathNotWritable    = &Error{Key: "path_err_not_writable", Exit: ExitPathNotWritable}
+	ErrNotEnoughSpace     = &Error{Key: "path_err_not_enough_space", Exit: ExitNotEnoughSpace}
+	ErrHookFailed         = &Error{Key: "hook_err_failed", Exit: ExitHookFailed}
+	ErrPayloadCorrupt     = &Error{Key: "payload_err_corrupt", Exit: ExitPayloadCorrupt}
+	ErrPayloadSignature   = &Error{Key: "payload_err_signature", Exit: ExitPayloadCorrupt}
+	ErrAborted            = &Error{Key: "err_aborted", Exit: ExitAborted}
+	ErrVerifyFailed       = &Error{Key: "verify_err_failed", Exit: ExitVerifyFailed}
+	ErrLicenseNotAccepted = &Error{Key: "err_cli_mustacceptlicense", Exit: ExitLicenseNotAccepted}
+	ErrRequirementsNotMet = &Error{Key: "requirements_err_not_met", Exit: ExitRequirementsNotMet}
+	ErrElevationFailed    = &Error{Key: "elevate_err_failed", Exit: ExitElevationFailed}
+)
+
+// Errors which are only relevant inside the package.
+var (
+	errPathOther        = &Error{Key: "path_err_other", Exit: ExitPathInvalid}
+	errComponentUnknown = &Error{Key: "components_err_unknown", Exit: ExitUsage}
+	errTypeUnknown      = &Error{Key: "type_err_unknown", Exit: ExitUsage}
+	errAnswersInvalid   = &Error{Key: "answers_err_invalid", Exit: ExitUsage}
+	errAnswersProduct   = &Error{Key: "answers_err_product", Exit: ExitUsage}
+	errAnswersNoTarget  = &Error{Key: "answers_err_no_target", Exit: ExitUsage}
+	errNotInstalled     = &Error{Key: "registry_err_not_installed", Exit: ExitNotInstalled}
+	errInstallAmbiguous = &Error{Key: "registry_err_ambiguous", Exit: ExitUsage}
+	errNoUninstaller    = &Error{Key: "registry_err_no_uninstaller", Exit: ExitNotInstalled}
+	errUninstallNoAdmin = &Error{Key: "registry_err_no_admin", Exit: ExitElevationFailed}
+	errUninstallFailed  = &Error{Key: "uninstall_err_incomplete", Exit: ExitError}
+	errNotResumable     = &Error{Key: "resume_err_unavailable", Exit: ExitUsage}
+	errTempDir          = &Error{Key: "temp_err_create", Exit: ExitError}
+	errGuiFailed        = &Error{Key: "err_gui_startup_failed", Exit: ExitGuiFailed}
+)
+
+// Error returns the translation key of the error.
+func (e *Error) Error() string { return e.Key }
+
+// Unwrap returns the underlying error, if any.
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is returns whether the target is an Error with the same key, regardless of the
+// underlying errors.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Key == e.Key
+}
+
+// wrap returns a copy of the error, which wraps the given underlying error.
+func (e *Error) wrap(err error) *Error {
+	return &Error{Key: e.Key, Exit: e.Exit, Err: err}
+}
+
+// ExitCode returns the exit status of the installer process for the given error. It is
+// ExitSuccess for nil, and ExitError for errors other than Error.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	var installerErr *Error
+	if errors.As(err, &installerErr) {
+		return installerErr.Exit
+	}
+	return ExitError
+}
+
+// ErrorMessage returns a localized message for the error, followed by the details of
+// any wrapped underlying error. Errors without a translation are returned as they are.
+func ErrorMessage(err error, translator *Translator) string {
+	message := translator.Get(err.Error())
+	if len(message) == 0 {
+		return err.Error()
+	}
+	if cause := errors.Unwrap(err); cause != nil {
+		message += "\n" + cause.Error()
+	}
+	return message
+}
@@ -0,0 +1,89 @@
+package linux_installer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, ExitSuccess},
+		{errors.New("other"), ExitError},
+		{&os.PathError{Op: "open", Path: "/x", Err: os.ErrNotExist}, ExitError},
+		{ErrPathInvalid, ExitPathInvalid},
+		{ErrPathNotWritable, ExitPathNotWritable},
+		{ErrNotEnoughSpace, ExitNotEnoughSpace},
+		{ErrHookFailed, ExitHookFailed},
+		{ErrPayloadCorrupt, ExitPayloadCorrupt},
+		{ErrPayloadSignature, ExitPayloadCorrupt},
+		{ErrAborted, ExitAborted},
+		{ErrVerifyFailed, ExitVerifyFailed},
+		{ErrLicenseNotAccepted, ExitLicenseNotAccepted},
+		{ErrRequirementsNotMet, ExitRequirementsNotMet},
+		{ErrElevationFailed, ExitElevationFailed},
+		{errComponentUnknown, ExitUsage},
+		{errNotInstalled, ExitNotInstalled},
+		{errUninstallNoAdmin, ExitElevationFailed},
+		{errUninstallFailed, ExitError},
+		{errGuiFailed.wrap(errors.New("plugin.Open")), ExitGuiFailed},
+		{ErrHookFailed.wrap(errors.New("exit status 3")), ExitHookFailed},
+		{fmt.Errorf("installing: %w", ErrNotEnoughSpace), ExitNotEnoughSpace},
+		{errors.Join(errors.New("other"), ErrAborted), ExitAborted},
+	}
+	for _, test := range tests {
+		if got := ExitCode(test.err); got != test.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	cause := errors.New("exit status 3")
+	tests := []struct {
+		err    error
+		target error
+		want   bool
+	}{
+		{ErrHookFailed, ErrHookFailed, true},
+		{ErrHookFailed.wrap(cause), ErrHookFailed, true},
+		{ErrHookFailed.wrap(cause), cause, true},
+		{&Error{Key: "hook_err_failed"}, ErrHookFailed, true},
+		{ErrHookFailed, ErrAborted, false},
+		{ErrPayloadSignature, ErrPayloadCorrupt, false},
+		{cause, ErrHookFailed, false},
+	}
+	for _, test := range tests {
+		if got := errors.Is(test.err, test.target); got != test.want {
+			t.Errorf("errors.Is(%v, %v) = %v", test.err, test.target, got)
+		}
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	translator := &Translator{
+		Variables: VariableMap{},
+		language:  DefaultLanguage,
+		langStrings: map[string]VariableMap{
+			DefaultLanguage: {"hook_err_failed": "Hook failed"},
+		},
+	}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{ErrHookFailed, "Hook failed"},
+		{ErrHookFailed.wrap(errors.New("exit status 3")), "Hook failed\nexit status 3"},
+		{ErrAborted, "err_aborted"},
+		{errors.New("other"), "other"},
+	}
+	for _, test := range tests {
+		if got := ErrorMessage(test.err, translator); got != test.want {
+			t.Errorf("ErrorMessage(%v) = %q, want %q", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package linux_installer
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventBufferSize is the number of events a subscriber may fall behind before the
+// installation waits for it, or drops ProgressEvents for it.
+const eventBufferSize = 64
+
+type (
+	// Event is something that happened during the installation, as received from
+	// Subscribe(). It is one of PhaseEvent, FileEvent, ProgressEvent, HookOutputEvent,
+	// FinishedEvent or RolledBackEvent, and has the progress in bytes at the time of the
+	// event.
+	Event interface {
+		Bytes() ByteProgress
+	}
+	// ByteProgress is the progress of the installation, as the number of bytes installed
+	// so far and in total. Rate is the current throughput in bytes per second, and ETA
+	// the estimated time until all files are installed at that rate. Both are 0 while
+	// they aren't known.
+	ByteProgress struct {
+		Done  int64
+		Total int64
+		Rate  int64
+		ETA   time.Duration
+	}
+	// PhaseEvent reports the start of a phase of the installation, or that it failed if
+	// Err is set. Phase is one of the Phase* constants other than PhaseFile, PhaseVerify,
+	// PhaseDone, PhaseFailed and PhaseRolledBack, which have their own events. Path is
+	// the file created in the launcher, desktop-shortcut and uninstaller phases.
+	PhaseEvent struct {
+		ByteProgress
+		Phase string
+		Path  string
+		Err   error
+	}
+	// FileEvent reports that a file is about to be installed (PhaseFile) or verified
+	// (PhaseVerify).
+	FileEvent struct {
+		ByteProgress
+		Phase string
+		File  *InstallFile
+	}
+	// ProgressEvent reports the progress while a file is copied, at most every
+	// progressEventInterval, so that large files don't stall the progress.
+	ProgressEvent struct {
+		ByteProgress
+		File *InstallFile
+	}
+	// HookOutputEvent is a line of output of a hook script, e.g. "pre-install".
+	HookOutputEvent struct {
+		ByteProgress
+		Hook string
+		Line string
+	}
+	// FinishedEvent reports the end of the installation, after the post-install phase,
+	// or as soon as it failed, in which case Err is set.
+	FinishedEvent struct {
+		ByteProgress
+		Err error
+	}
+	// RolledBackEvent reports that the installation was canceled and rolled back.
+	RolledBackEvent struct {
+		ByteProgress
+	}
+	// hookOutput is an io.Writer for the output of a hook script, which emits each line
+	// as a HookOutputEvent. It may be written to from several goroutines.
+	hookOutput struct {
+		installer *Installer
+		hook      string
+		partial   []byte
+		lock      sync.Mutex
+	}
+)
+
+// Bytes implements Event.
+func (p ByteProgress) Bytes() ByteProgress { return p }
+
+// Fraction returns the ratio of installed bytes to all bytes, between 0.0 and 1.0.
+func (p ByteProgress) Fraction() float64 {
+	if p.Total == 0 {
+		return 0.0
+	}
+	return float64(p.Done) / float64(p.Total)
+}
+
+// RateString returns the rate human-readable, e.g. "12.30 MiB/s", or an empty string if
+// it isn't known.
+func (p ByteProgress) RateString() string {
+	if p.Rate == 0 {
+		return ""
+	}
+	return sizeString(p.Rate) + "/s"
+}
+
+// ETAString returns the ETA as minutes and seconds, e.g. "2:05", with hours in front if
+// needed, or an empty string if it isn't known.
+func (p ByteProgress) ETAString() string {
+	if p.ETA == 0 {
+		return ""
+	}
+	seconds := int64(p.ETA / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// Subscribe returns a channel which receives the events of the installation from now
+// on. The channel is closed after the FinishedEvent or RolledBackEvent. Subscribers must
+// keep receiving events until then, since the installation waits for them once they
+// fall too far behind. ProgressEvents are dropped for a subscriber that fell behind
+// instead, since the next one reports the progress anyway.
+func (i *Installer) Subscribe() <-chan Event {
+	i.subscribersLock.Lock()
+	defer i.subscribersLock.Unlock()
+	events := make(chan Event, eventBufferSize)
+	i.subscribers = append(i.subscribers, events)
+	return events
+}
+
+// emit sends an event to all subscribers, and closes their channels if the event ends
+// the installation. It must not be called while holding the statusLock, so that
+// subscribers can still query the installer. Events are emitted one at a time, but
+// without holding the subscribersLock, so that a subscriber that fell behind doesn't
+// keep others from subscribing.
+func (i *Installer) emit(event Event) {
+	i.emitLock.Lock()
+	defer i.emitLock.Unlock()
+	i.subscribersLock.Lock()
+	subscribers := i.subscribers
+	i.subscribersLock.Unlock()
+	_, progress := event.(ProgressEvent)
+	for _, events := range subscribers {
+		if !progress {
+			events <- event
+			continue
+		}
+		select {
+		case events <- event:
+		default:
+		}
+	}
+	switch event.(type) {
+	case FinishedEvent, RolledBackEvent:
+		i.subscribersLock.Lock()
+		for _, events := range i.subscribers {
+			close(events)
+		}
+		i.subscribers = nil
+		i.subscribersLock.Unlock()
+	}
+}
+
+// byteProgress returns the current progress in bytes.
+func (i *Installer) byteProgress() ByteProgress {
+	i.statusLock.Lock()
+	defer i.statusLock.Unlock()
+	return i.currentProgress(time.Now())
+}
+
+// event returns the Event for a status with the given progress, or nil if the status
+// has no phase.
+func (s InstallStatus) event(progress ByteProgress) Event {
+	switch s.Phase {
+	case "":
+		return nil
+	case PhaseFile, PhaseVerify:
+		return FileEvent{ByteProgress: progress, Phase: s.Phase, File: s.File}
+	case PhaseDone, PhaseFailed:
+		return FinishedEvent{ByteProgress: progress, Err: s.Err}
+	case PhaseRolledBack:
+		return RolledBackEvent{ByteProgress: progress}
+	case PhaseLauncher, PhaseDesktopShortcut, PhaseUninstaller:
+		return PhaseEvent{ByteProgress: progress, Phase: s.Phase, Path: s.S, Err: s.Err}
+	}
+	return PhaseEvent{ByteProgress: progress, Phase: s.Phase, Err: s.Err}
+}
+
+// Write implements io.Writer, and emits all complete lines written so far.
+func (o *hookOutput) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.partial = append(o.partial, p...)
+	for {
+		end := bytes.IndexByte(o.partial, '\n')
+		if end < 0 {
+			break
+		}
+		o.emitLine(string(o.partial[:end]))
+		o.partial = o.partial[end+1:]
+	}
+	return len(p), nil
+}
+
+// flush emits the last line of the output, if it didn't end with a newline.
+func (o *hookOutput) flush() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if len(o.partial) > 0 {
+		o.emitLine(string(o.partial))
+		o.partial = nil
+	}
+}
+
+// emitLine logs a line of output, and emits it as a HookOutputEvent.
+func (o *hookOutput) emitLine(line string) {
+	line = strings.TrimRight(line, "\r")
+	slog.Info("Hook output", "hook", o.hook, "line", line)
+	o.installer.emit(HookOutputEvent{
+		ByteProgress: o.installer.byteProgress(),
+		Hook:         o.hook,
+		Line:         line,
+	})
+}
@@ -6,6 +6,8 @@ require (
 	github.com/GeertJohan/go.rice v1.0.3
 	github.com/cloudfoundry/jibber_jabber v0.0.0-20151120183258-bcc4c8345a21
 	github.com/gotk3/gotk3 v0.6.4
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.15
 	golang.org/x/sys v0.32.0
 	golang.org/x/text v0.24.0
 	gopkg.in/yaml.v2 v2.4.0
@@ -4,9 +4,10 @@ import (
 	// this is the installer package name - here it refers to the parent directory
 	"github.com/grandchild/linux_installer"
 
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -74,6 +75,20 @@ type (
 		quitDialog       *gtk.Dialog
 		licenseBuf       *gtk.TextBuffer
 		runInstalled     *gtk.CheckButton
+		shortcutMenu     *gtk.CheckButton
+		shortcutDesktop  *gtk.CheckButton
+		pathTypeUpdate   *gtk.RadioButton
+		pathTypeRepair   *gtk.RadioButton
+		allUsers         *gtk.CheckButton
+		resumeButton     *gtk.RadioButton
+		cleanUpButton    *gtk.RadioButton
+		typeButtons      map[string]*gtk.RadioButton
+		componentsStore  *gtk.TreeStore
+		componentsTree   *gtk.TreeView
+		requirements     []linux_installer.UnmetRequirement
+		requirementsErr  error
+		cancelInstall    context.CancelFunc
+		installErr       error
 		curScreen        int
 		screenNames      []string
 		screens          []Screen
@@ -87,6 +102,16 @@ type (
 
 const displayKey = "_language_display"
 
+// Columns of the components-store tree model in the GUI definition file.
+const (
+	componentColumnSelected = iota
+	componentColumnSelectable
+	componentColumnTitle
+	componentColumnSize
+	componentColumnId
+	componentColumnDescription
+)
+
 // guiEventHandler returns an EventHandler that handles events from the GTK3 elements in
 // the .glade GUI-definition file, such as buttons, entries and window events.
 func guiEventHandler(g *Gui) (handler EventHandler) {
@@ -101,18 +126,21 @@ func guiEventHandler(g *Gui) (handler EventHandler) {
 		"on_path_browse_clicked":      func() { g.browseInstallDir() },
 		"on_path_reset_clicked":       func() { g.resetInstallDir() },
 		"on_path_entry_changed":       func() { g.checkInstallDir() },
+		"on_path_all_users_toggled":   func() { g.setAllUsersFromOption() },
+		"on_path_type_toggled":        func() { g.updateInstallType() },
 		"on_main_destroy":             func() { gtk.MainQuit() },
+		"on_component_toggled": func(_ *gtk.CellRendererToggle, path string) {
+			g.toggleComponent(path)
+		},
 	}
 }
 
 // internalEventHandler, as opposed to guiEventHandler, returns an EventHandler that
-// responds to events emitted by the behavioral GUI code, such as installation_finished,
-// or to update the progress bar during installation.
+// responds to events emitted by the behavioral GUI code, such as installation_finished.
 func internalEventHandler(g *Gui) (handler EventHandler) {
 	return EventHandler{
 		"on_installation_finished": g.showResultScreen,
 		"on_undo_finished":         g.prevScreen,
-		"update_progressbar":       g.updateProgressbar,
 	}
 }
 
@@ -152,40 +180,74 @@ func screenHandlers(g *Gui) (handlers []ScreenHandler) {
 				g.nextButton.SetLabel(g.t("button_license_accept"))
 			},
 		},
+		{
+			name:     "type",
+			disabled: len(g.installer.InstallTypes()) == 0,
+			before: func() {
+				g.setInstallTypeOptions()
+			},
+			after: func() {
+				g.setInstallTypeFromOptions()
+			},
+		},
+		{
+			name:     "components",
+			disabled: len(g.installer.Components()) == 0,
+			before: func() {
+				g.setComponentOptions()
+			},
+		},
+		{
+			name:     "requirements",
+			disabled: len(g.requirements) == 0,
+			before: func() {
+				g.setRequirementsText()
+			},
+		},
 		{
 			name: "path",
 			before: func() {
-				g.nextButton.SetLabel(g.t("button_install"))
+				g.setInstallButtonLabel()
 				g.nextButton.SetSensitive(false)
+				g.setAllUsersOption()
 				g.resetInstallDir()
 				g.checkInstallDir()
 			},
+			after: func() {
+				g.setResumeFromOptions()
+			},
+		},
+		{
+			name:     "shortcut",
+			disabled: !g.installer.StartCommandAvailable(),
+			before: func() {
+				g.setInstallButtonLabel()
+				g.setShortcutOptions()
+			},
+			after: func() {
+				g.setShortcutsFromOptions()
+			},
 		},
 		{
 			name: "progress",
 			before: func() {
 				g.backButton.SetLabel(g.t("button_abort"))
 				g.nextButton.SetSensitive(false)
-				g.installer.PreInstall()
-				g.installer.StartInstall()
-				glib.IdleAdd(g.installationProgress)
+				g.installer.Language = g.translator.GetLanguage()
+				g.startInstallation()
 			},
 			undo: func() bool {
 				g.backButton.SetSensitive(false)
-				if !g.installer.Done {
-					go g.installer.Rollback()
+				if g.cancelInstall != nil {
+					g.cancelInstall()
+					return false // wait for installer undo
 				}
-				return g.installer.Done // wait for installer undo
+				return true
 			},
 		},
 		{
 			name: "success",
 			before: func() {
-				g.installer.CreateLauncher = !g.config.NoLauncher
-				g.installer.PostInstall(
-					g.translator.Variables,
-					g.translator.GetAllStringsRaw(),
-				)
 				g.quitButton.SetSensitive(false)
 				g.backButton.SetSensitive(false)
 				g.nextButton.SetLabel(g.t("button_exit"))
@@ -193,8 +255,11 @@ func screenHandlers(g *Gui) (handlers []ScreenHandler) {
 					g.runInstalled.SetSensitive(false)
 					g.runInstalled.SetVisible(false)
 				}
+				// right away, since the window may be closed instead of exited
+				g.recordAnswers()
 			},
 			after: func() {
+				g.recordAnswers() // again, with the final choice to run the application
 				if g.runInstalled.GetActive() {
 					g.installer.ExecInstalled()
 				}
@@ -249,6 +314,15 @@ func NewGui(
 		quitDialog:       getDialog(builder, "quit-dialog"),
 		licenseBuf:       getTextBuffer(builder, "license-buf"),
 		runInstalled:     getCheckButton(builder, "success-run-checkbox"),
+		shortcutMenu:     getCheckButton(builder, "shortcut-menu-checkbox"),
+		shortcutDesktop:  getCheckButton(builder, "shortcut-desktop-checkbox"),
+		pathTypeUpdate:   getRadioButton(builder, "path-type-update"),
+		pathTypeRepair:   getRadioButton(builder, "path-type-repair"),
+		allUsers:         getCheckButton(builder, "path-all-users"),
+		resumeButton:     getRadioButton(builder, "path-unfinished-resume"),
+		cleanUpButton:    getRadioButton(builder, "path-unfinished-clean-up"),
+		componentsStore:  getTreeStore(builder, "components-store"),
+		componentsTree:   getTreeView(builder, "components-tree"),
 		curScreen:        0,
 		translator:       translator,
 		config:           config,
@@ -258,6 +332,7 @@ func NewGui(
 	gui.setLabel("header-text", gui.t("header_text"))
 	gui.loadAndApplyConfigCss()
 
+	gui.requirements, gui.requirementsErr = installer.CheckRequirements()
 	gui.builder.ConnectSignals(guiEventHandler(gui))
 	for signal, handler := range internalEventHandler(gui) {
 		glib.SignalNew(signal)
@@ -402,7 +477,7 @@ func (g *Gui) browseInstallDir() {
 	)
 	if err != nil {
 		g.setLabel("path-error-text", g.t("err_couldnt_open_install_path_dialog"))
-		log.Println(g.t("err_couldnt_open_install_path_dialog"))
+		slog.Error("Unable to open directory chooser", linux_installer.ErrorAttr(err))
 		return
 	}
 	// set some default folder here?
@@ -414,30 +489,72 @@ func (g *Gui) browseInstallDir() {
 }
 
 // resetInstallDir resets the path edit field to the predefined default path, which is a
-// subdirectory within the user's home. The string for the subdirectory may be set in
-// the config through "default_install_dir_name".
+// subdirectory within the user's home, or e.g. /opt when installing for all users. The
+// string for the subdirectory may be set in the config through
+// "default_install_dir_name". If a previous installation was found, its directory is
+// used instead.
 func (g *Gui) resetInstallDir() {
-	g.dirPathEdit.SetText(filepath.Join(
-		glib.GetHomeDir(),
-		g.translator.Expand(g.config.DefaultInstallDirName),
-	))
+	if previous := g.installer.PreviousInstall(); previous != nil {
+		g.dirPathEdit.SetText(previous.Target)
+		return
+	}
+	g.dirPathEdit.SetText(g.defaultInstallDir())
+}
+
+// defaultInstallDir returns the predefined default path, see resetInstallDir().
+func (g *Gui) defaultInstallDir() string {
+	parent := glib.GetHomeDir()
+	if g.installer.Elevate {
+		parent = linux_installer.AllUsersInstallDir()
+	}
+	return filepath.Join(parent, g.translator.Expand(g.config.DefaultInstallDirName))
+}
+
+// setAllUsersOption shows the option to install for all users if the installer can run
+// itself with administrator rights, and sets it to the installer's current choice.
+func (g *Gui) setAllUsersOption() {
+	g.allUsers.SetVisible(linux_installer.CanElevate(true))
+	g.allUsers.SetActive(g.installer.Elevate)
+}
+
+// setAllUsersFromOption applies the choice of the all-users checkbox to the installer.
+// If the path edit field still has the default path, it is changed to the default path
+// for the new choice.
+func (g *Gui) setAllUsersFromOption() {
+	if g.installer.Elevate == g.allUsers.GetActive() {
+		return
+	}
+	dirName, _ := g.dirPathEdit.GetText()
+	wasDefault := dirName == g.defaultInstallDir()
+	g.installer.Elevate = g.allUsers.GetActive()
+	if wasDefault {
+		g.dirPathEdit.SetText(g.defaultInstallDir())
+	}
+	g.checkInstallDir()
 }
 
 // checkInstallDir is run whenever the path edit content changes, and tests whether the
 // path is valid, its parent writable and whether there is enough space on the disk that
 // the path is on. In case of errors it prints them to the (usually invisible) error
-// label in the GUI.
+// label in the GUI. If the path isn't writable, it points to the option to install for
+// all users if that is available.
 func (g *Gui) checkInstallDir() {
 	g.nextButton.SetSensitive(true)
 	dirName, _ := g.dirPathEdit.GetText()
 	err := g.installer.CheckSetInstallDir(dirName)
 	if err != nil {
-		g.setLabel("path-error-text", g.t(err.Error()))
+		message := g.t(err.Error())
+		if errors.Is(err, linux_installer.ErrPathNotWritable) && g.allUsers.GetVisible() {
+			message += " " + g.t("path_err_not_writable_all_users")
+		}
+		g.setLabel("path-error-text", message)
 		g.nextButton.SetSensitive(false)
 	} else {
 		g.setLabel("path-error-text", "")
 	}
-	g.setLabel("path-space-required", g.installer.SizeString())
+	g.updateInstallType()
+	g.updateUnfinishedOptions()
+	g.setLabel("path-space-required", g.installer.SpaceRequiredString())
 	g.setLabel("path-space-available", g.installer.SpaceString())
 	if !g.installer.DiskSpaceSufficient() {
 		g.setLabel("path-error-text", g.t("path_err_not_enough_space"))
@@ -445,6 +562,232 @@ func (g *Gui) checkInstallDir() {
 	}
 }
 
+// setRequirementsText lists the system requirements that aren't met, and disables the
+// next button if any of them prevents the installation.
+func (g *Gui) setRequirementsText() {
+	messages := make([]string, 0, len(g.requirements))
+	for _, requirement := range g.requirements {
+		message := requirement.Message(g.translator)
+		if requirement.Warning {
+			message = g.t("requirements_warning") + " " + message
+		}
+		messages = append(messages, "• "+message)
+	}
+	g.setLabel("requirements-list", strings.Join(messages, "\n"))
+	if g.requirementsErr != nil {
+		g.setLabel("requirements-result-text", g.t("requirements_failed_text"))
+		g.nextButton.SetSensitive(false)
+	} else {
+		g.setLabel("requirements-result-text", g.t("requirements_warning_text"))
+	}
+}
+
+// setInstallButtonLabel labels the next button "Install" if the next screen starts the
+// installation.
+func (g *Gui) setInstallButtonLabel() {
+	if g.screens[g.skipDisabledScreen(g.curScreen+1)].name == "progress" {
+		g.nextButton.SetLabel(g.t("button_install"))
+	}
+}
+
+// setShortcutOptions sets the shortcut checkboxes to the installer's current choice. If
+// the chosen installation type doesn't create a launcher, both are unchecked and
+// disabled.
+func (g *Gui) setShortcutOptions() {
+	available := g.installer.LauncherAvailable()
+	g.shortcutMenu.SetSensitive(available)
+	g.shortcutDesktop.SetSensitive(available)
+	g.shortcutMenu.SetActive(available && g.installer.CreateLauncher)
+	g.shortcutDesktop.SetActive(available && g.installer.CreateDesktopShortcut)
+}
+
+// setShortcutsFromOptions applies the choice of the shortcut checkboxes to the
+// installer.
+func (g *Gui) setShortcutsFromOptions() {
+	if !g.installer.LauncherAvailable() {
+		return
+	}
+	g.installer.CreateLauncher = g.shortcutMenu.GetActive()
+	g.installer.CreateDesktopShortcut = g.shortcutDesktop.GetActive()
+}
+
+// recordAnswers writes all choices made in the GUI to the answer file given on the
+// command line, if any. The answers are written once the installation succeeded, and
+// again on exit, when the choice to run the installed application is final.
+func (g *Gui) recordAnswers() {
+	if len(g.config.RecordAnswersFile) == 0 {
+		return
+	}
+	answers := g.installer.Answers(
+		g.translator.GetLanguage(),
+		g.runInstalled.GetActive(),
+	)
+	err := answers.Save(g.config.RecordAnswersFile)
+	if err != nil {
+		slog.Warn(
+			"Unable to record answers", "path", g.config.RecordAnswersFile,
+			linux_installer.ErrorAttr(err),
+		)
+	}
+}
+
+// setInstallTypeOptions fills the type screen with a radio button, and a description
+// below it, for each installation type from the config, and selects the current type.
+func (g *Gui) setInstallTypeOptions() {
+	box := getBox(g.builder, "type-options")
+	emptyGtkContainer(box)
+	g.typeButtons = make(map[string]*gtk.RadioButton)
+	var group *gtk.RadioButton
+	current := g.installer.InstallType()
+	for _, t := range g.installer.InstallTypes() {
+		button, err := gtk.RadioButtonNewWithLabelFromWidget(
+			group, g.translator.Expand(t.Label),
+		)
+		if err != nil {
+			slog.Error("Unable to create type button", linux_installer.ErrorAttr(err))
+			continue
+		}
+		if group == nil {
+			group = button
+		}
+		button.SetActive(current != nil && current.Id == t.Id)
+		box.PackStart(button, false, true, 0)
+		if t.Description != "" {
+			description, err := gtk.LabelNew(g.translator.Expand(t.Description))
+			if err == nil {
+				description.SetLineWrap(true)
+				description.SetHAlign(gtk.ALIGN_START)
+				description.SetMarginStart(25)
+				box.PackStart(description, false, true, 0)
+			}
+		}
+		g.typeButtons[t.Id] = button
+	}
+	box.ShowAll()
+}
+
+// setInstallTypeFromOptions applies the installation type selected on the type screen.
+// The type is only applied if it changed, because that resets the component selection.
+func (g *Gui) setInstallTypeFromOptions() {
+	current := g.installer.InstallType()
+	for id, button := range g.typeButtons {
+		if button.GetActive() && (current == nil || current.Id != id) {
+			g.installer.SetInstallType(id)
+		}
+	}
+}
+
+// setComponentOptions fills the components tree with all components from the config.
+// A component is shown as a child of the first component it depends on, if that one
+// comes before it in the config.
+func (g *Gui) setComponentOptions() {
+	g.componentsStore.Clear()
+	parents := make(map[string]*gtk.TreeIter)
+	for _, c := range g.installer.Components() {
+		var parent *gtk.TreeIter
+		if len(c.Depends) > 0 {
+			parent = parents[c.Depends[0]]
+		}
+		iter := g.componentsStore.Append(parent)
+		g.componentsStore.SetValue(iter, componentColumnTitle, g.translator.Expand(c.Title))
+		g.componentsStore.SetValue(iter, componentColumnSize, g.installer.ComponentSizeString(c.Id))
+		g.componentsStore.SetValue(iter, componentColumnId, c.Id)
+		g.componentsStore.SetValue(
+			iter, componentColumnDescription, g.translator.Expand(c.Description),
+		)
+		parents[c.Id] = iter
+	}
+	g.componentsTree.ExpandAll()
+	g.updateComponentSelection()
+}
+
+// toggleComponent is called when a checkbox in the components tree is clicked, and
+// (de)selects the component in the row given by the tree path string.
+func (g *Gui) toggleComponent(path string) {
+	iter, err := g.componentsStore.GetIterFromString(path)
+	if err != nil {
+		return
+	}
+	value, err := g.componentsStore.GetValue(iter, componentColumnId)
+	if err != nil {
+		return
+	}
+	id, _ := value.GetString()
+	g.installer.SelectComponent(id, !g.installer.ComponentSelected(id))
+	g.updateComponentSelection()
+}
+
+// updateComponentSelection updates all checkboxes in the components tree from the
+// installer's component selection, since (de)selecting one component may affect others
+// through their dependencies. It also updates the total size of the installation.
+func (g *Gui) updateComponentSelection() {
+	g.componentsStore.ForEach(
+		func(model *gtk.TreeModel, path *gtk.TreePath, iter *gtk.TreeIter) bool {
+			value, err := model.GetValue(iter, componentColumnId)
+			if err != nil {
+				return false
+			}
+			id, _ := value.GetString()
+			g.componentsStore.SetValue(
+				iter, componentColumnSelected, g.installer.ComponentSelected(id),
+			)
+			g.componentsStore.SetValue(
+				iter, componentColumnSelectable, !g.installer.ComponentRequired(id),
+			)
+			return false
+		},
+	)
+	g.setLabel("components-space-required", g.installer.SizeString())
+}
+
+// updateInstallType shows the choice between updating or repairing a previous
+// installation and a normal installation, if a previous installation was found, and
+// applies the choice to the installer. Updating and repairing are only possible if the
+// path edit field contains the previous installation's directory.
+func (g *Gui) updateInstallType() {
+	previous := g.installer.PreviousInstall()
+	getBox(g.builder, "path-type-box").SetVisible(previous != nil)
+	if previous == nil {
+		return
+	}
+	dirName, _ := g.dirPathEdit.GetText()
+	samePath := filepath.Clean(dirName) == filepath.Clean(previous.Target)
+	g.pathTypeUpdate.SetSensitive(samePath)
+	g.pathTypeRepair.SetSensitive(samePath)
+	g.installer.Update = g.pathTypeUpdate.GetActive()
+	g.installer.Repair = g.pathTypeRepair.GetActive()
+}
+
+// updateUnfinishedOptions shows the choice between resuming and cleaning up an
+// unfinished installation, if there is one in the location from the path edit field.
+// Resuming is only possible if it is an installation of the same version.
+func (g *Gui) updateUnfinishedOptions() {
+	unfinished := g.installer.UnfinishedInstall()
+	getBox(g.builder, "path-unfinished-box").SetVisible(unfinished != nil)
+	if unfinished == nil {
+		return
+	}
+	resumable := g.installer.Resumable()
+	g.resumeButton.SetSensitive(resumable)
+	if !resumable {
+		g.cleanUpButton.SetActive(true)
+	}
+}
+
+// setResumeFromOptions applies the choice between resuming and cleaning up an
+// unfinished installation to the installer. Resuming also takes over the installation
+// type and components of the unfinished installation.
+func (g *Gui) setResumeFromOptions() {
+	g.installer.Resume = false
+	if g.installer.UnfinishedInstall() == nil || !g.resumeButton.GetActive() {
+		return
+	}
+	err := g.installer.ResumeUnfinished()
+	if err != nil {
+		slog.Warn("Unable to resume installation", linux_installer.ErrorAttr(err))
+	}
+}
+
 // t returns a localized string for the key, and expands any template variables therein.
 // Variables are surrounded by double braces and preceded by a dot like this:
 // 	{{.var}}
@@ -511,7 +854,7 @@ func (g *Gui) setLicenseText() error {
 		"licenses", regexp.MustCompile(`.+\.txt`),
 	)
 	if err != nil || len(licenseFiles) == 0 {
-		log.Println("No license files found")
+		slog.Error("No license files found")
 		return errors.New("No license files found")
 	}
 	licenseFilename := fmt.Sprintf(
@@ -520,17 +863,17 @@ func (g *Gui) setLicenseText() error {
 		"licenses/license_%s.txt", linux_installer.DefaultLanguage)
 	licenseText, available := licenseFiles[licenseFilename]
 	if !available {
-		log.Println(fmt.Sprintf("No license file: %s", licenseFilename))
+		slog.Warn("No license file", "file", licenseFilename)
 		licenseText, available = licenseFiles[fallbackLicenseFilename]
 		if !available {
-			log.Println(fmt.Sprintf("No license file: %s", fallbackLicenseFilename))
+			slog.Warn("No license file", "file", fallbackLicenseFilename)
 			for file, text := range licenseFiles {
-				log.Println(fmt.Sprintf("Fallback to: %s", file))
+				slog.Info("Falling back to license file", "file", file)
 				licenseText = text
 				break
 			}
 		} else {
-			log.Println(fmt.Sprintf("Fallback to: %s", fallbackLicenseFilename))
+			slog.Info("Falling back to license file", "file", fallbackLicenseFilename)
 		}
 	}
 	g.licenseBuf.SetText(licenseText)
@@ -553,7 +896,7 @@ func (g *Gui) translateAllLabels(item interface{}) {
 		case "GtkLabel":
 			label := (*gtk.Label)(unsafe.Pointer(widget))
 			g.translateLabel(label)
-		case "GtkCheckButton":
+		case "GtkCheckButton", "GtkRadioButton":
 			fallthrough
 		case "GtkButton":
 			button := (*gtk.Button)(unsafe.Pointer(widget))
@@ -584,41 +927,85 @@ func (g *Gui) translateButton(button *gtk.Button) {
 	}
 }
 
-// installationProgress is a glib.Idle function that gets called repeatedly unless it
-// returns false. During the file copy process this function checks on the status of the
-// installer and emits update signals for the progress bar and finish or undo handlers.
-func (g *Gui) installationProgress() (repeat bool) {
-	status := g.installer.Status
-	g.win.Emit("update_progressbar")
-	if status.Done {
-		g.win.Emit("on_installation_finished")
-		return false
-	}
-	if status.Aborted {
-		g.win.Emit("on_undo_finished")
-		return false
-	}
-	return true
+// startInstallation runs the pre-install hook, the installation and the post-install
+// hook in the background. When installing for all users, the elevated installer runs
+// the whole installation instead, and the GUI keeps running as the user. The
+// installer's events are passed on to the GTK main loop to update the progress bar.
+// Once the installation returns, the result screen is shown, or the previous screen if
+// the installation was canceled (see cancelInstall) and rolled back.
+func (g *Gui) startInstallation() {
+	events := g.installer.Subscribe()
+	go func() {
+		for event := range events {
+			glib.IdleAdd(func() { g.updateProgressbar(event) })
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	g.cancelInstall = cancel
+	go func() {
+		var err error
+		if g.installer.Elevate {
+			err = g.installer.InstallElevated(
+				ctx, true, g.translator.Variables, g.translator.GetAllStringsRaw(),
+			)
+		} else {
+			err = g.installer.PreInstall(
+				g.translator.Variables,
+				g.translator.GetAllStringsRaw(),
+			)
+			if err == nil {
+				err = g.installer.InstallContext(ctx)
+			}
+			if err == nil {
+				err = g.installer.PostInstall(
+					g.translator.Variables,
+					g.translator.GetAllStringsRaw(),
+				)
+			}
+		}
+		glib.IdleAdd(func() {
+			cancel()
+			g.cancelInstall = nil
+			if errors.Is(err, linux_installer.ErrAborted) {
+				g.win.Emit("on_undo_finished")
+			} else {
+				g.installErr = err
+				g.win.Emit("on_installation_finished")
+			}
+		})
+	}()
 }
 
-// updateProgressbar updates the progress bar with the current filename and the
-// percentage of bytes copies to disk so far.
-func (g *Gui) updateProgressbar() {
-	installingFile := g.installer.NextFile()
-	if installingFile != nil {
-		g.progressBar.SetText(installingFile.Target)
+// updateProgressbar updates the progress bar from an installer event, with the file
+// being installed and the percentage of bytes copied to disk so far, and shows the
+// current rate and the estimated remaining time below it. While the installed files are
+// verified, it shows the file being verified.
+func (g *Gui) updateProgressbar(event linux_installer.Event) {
+	if fileEvent, ok := event.(linux_installer.FileEvent); ok && fileEvent.File != nil {
+		if fileEvent.Phase == linux_installer.PhaseVerify {
+			g.progressBar.SetText(g.t("progress_verifying") + " " + fileEvent.File.Target)
+		} else {
+			g.progressBar.SetText(fileEvent.File.Target)
+		}
+	}
+	progress := event.Bytes()
+	g.progressBar.SetProgressFraction(progress.Fraction())
+	rate := progress.RateString()
+	if progress.ETA > 0 {
+		rate += ", " + progress.ETAString() + " " + g.t("progress_remaining")
 	}
-	g.progressBar.SetProgressFraction(g.installer.Progress())
+	g.setLabel("progress-rate", rate)
 }
 
-// showResultScreen gets called after the file copy process stops, checks on the status
-// of the installer, and changes to the appropriate final screen of the installer GUI,
-// success or failure.
+// showResultScreen gets called after the file copy process stops, checks on the result
+// of the installation, and changes to the appropriate final screen of the installer
+// GUI, success or failure.
 func (g *Gui) showResultScreen() {
 	g.setLabel("failure-error-text", "")
-	if g.installer.Error() != nil {
-		log.Println(g.installer.Error().Error())
-		g.setLabel("failure-error-text", g.installer.Error().Error())
+	if err := g.installErr; err != nil {
+		message := linux_installer.ErrorMessage(err, g.translator)
+		slog.Error("Installation failed", linux_installer.ErrorAttr(err))
+		g.setLabel("failure-error-text", message)
 		g.showNamedScreen("failure")
 	} else {
 		g.showNamedScreen("success")
@@ -156,3 +156,30 @@ func getCheckButton(builder *gtk.Builder, name string) *gtk.CheckButton {
 		return nil
 	}
 }
+
+func getRadioButton(builder *gtk.Builder, name string) *gtk.RadioButton {
+	obj := getObject(builder, name)
+	if w, ok := obj.(*gtk.RadioButton); ok {
+		return w
+	} else {
+		return nil
+	}
+}
+
+func getTreeStore(builder *gtk.Builder, name string) *gtk.TreeStore {
+	obj := getObject(builder, name)
+	if w, ok := obj.(*gtk.TreeStore); ok {
+		return w
+	} else {
+		return nil
+	}
+}
+
+func getTreeView(builder *gtk.Builder, name string) *gtk.TreeView {
+	obj := getObject(builder, name)
+	if w, ok := obj.(*gtk.TreeView); ok {
+		return w
+	} else {
+		return nil
+	}
+}
@@ -1,15 +1,17 @@
 package linux_installer
 
 import (
-	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
@@ -24,122 +26,246 @@ const (
 	PiB       = 1024 * TiB
 )
 
+// defaultInstallWorkersMax is the maximum number of files installed at the same time,
+// unless "install_workers" is set in the config.
+const defaultInstallWorkersMax = 8
+
+// partialFileSuffix is appended to the hidden name under which a file is written, until
+// it is complete and renamed to its target, see writeFile().
+const partialFileSuffix = ".installing"
+
 type (
-	// InstallFile is an augmented zip.FileInfo struct with both source and target path as
+	// InstallFile is an augmented PayloadFile struct with both source and target path as
 	// well as a flag indicating wether the file has been copied to the target or not.
 	// Source and target path will be the same if the installation doesn't run from a
 	// subdir of the source data.
+	//
+	// When updating a previous installation, files that didn't change are not copied but
+	// flagged as unchanged. Any existing files that are overwritten are moved to the
+	// backup path first. Symlinks have the target of the installed link, and hard links
+	// the file they link to, if that is part of the payload. While a file is copied, the
+	// number of bytes copied so far is added to the progress.
 	InstallFile struct {
-		*zip.File
+		*PayloadFile
 		Target    string
 		installed bool
+		unchanged bool
+		copied    int64
+		backup    string
+		sha256    string
+		link      string
+		hardLink  *InstallFile
 	}
 	// InstallStatus is a message struct that gets passed around at various times in the
 	// installation process. All fields are optional and contain the current file, a status
 	// string, wether the installer as a whole is finished or not, or wether it's been
 	// aborted and rolled back.
+	//
+	// Phase is one of the Phase* constants, and Err is set if that phase failed.
+	// BytesDone and BytesTotal denote the progress at the time of the status.
 	InstallStatus struct {
-		S       string
-		File    *InstallFile
-		Done    bool
-		Aborted bool
+		S          string
+		File       *InstallFile
+		Done       bool
+		Aborted    bool
+		Phase      string
+		Err        error
+		BytesDone  int64
+		BytesTotal int64
 	}
 	// Installer represents a set of files and a target to be copied into. It contains
-	// information about the files, size, and status (done or not), as well as 3 different
-	// message channels, for each abort and its confirmation as well as status channel.
+	// information about the files, size, and status (done or not). The installation runs
+	// with InstallContext(), and reports its progress as events to subscribers (see
+	// Subscribe()). Status() and Done() are kept for the older StartInstall() API.
+	//
+	// If Update is set and the target is the directory of a previous installation (see
+	// PreviousInstall()), then only changed files are copied, and files of the previous
+	// installation which are no longer part of the payload are removed.
+	//
+	// Language is the language chosen for the installation, which is recorded for the
+	// uninstaller.
+	//
+	// If Staged is set, the files are installed into a hidden staging directory next to
+	// the target first, and moved into place only after they were verified (see
+	// staging.go). An aborted or failed installation then never touches the target. The
+	// installation it replaced is kept until PostInstall() succeeded, and moved back by
+	// a rollback.
+	//
+	// If Repair is set and the target is the directory of an installation of the same
+	// product (see Repairing()), the files of that installation are checked against the
+	// payload, and only missing or damaged ones are written again. Repairs are never
+	// staged.
+	//
+	// While files are installed, they are recorded in a journal (see journal.go). If
+	// Resume is set (see ResumeUnfinished()), an unfinished installation in the target
+	// is resumed from its journal. Otherwise it is cleaned up before installing.
+	//
+	// If Elevate is set, the installation is run by a copy of the installer with
+	// administrator rights instead (see InstallElevated()), so that it can install into
+	// directories of all users, e.g. /opt.
 	Installer struct {
-		Target               string
-		Status               *InstallStatus
-		CreateLauncher       bool
-		Done                 bool
-		tempPath             string
-		dataPrepared         bool
-		hooksPrepared        bool
-		existingTargetParent string
-		totalSize            int64
-		installedSize        int64
-		files                []*InstallFile
-		doneChannel          chan bool
-		abortChannel         chan bool
-		abortConfirmChannel  chan bool
-		actionLock           sync.Mutex
-		progressFunction     func(InstallStatus)
-		config               *Config
-		err                  error
+		Target                string
+		Language              string
+		CreateLauncher        bool
+		CreateDesktopShortcut bool
+		Update                bool
+		Staged                bool
+		Repair                bool
+		Resume                bool
+		Elevate               bool
+		tempPath              string
+		dataPrepared          bool
+		hooksPrepared         bool
+		existingTargetParent  string
+		staging               string
+		committed             bool
+		replaced              string
+		journal               *journalWriter
+		totalSize             int64
+		installedSize         int64
+		rate                  rateMeter
+		progressEmitted       time.Time
+		files                 []*InstallFile
+		allFiles              []*InstallFile
+		selectedComponents    map[string]bool
+		installType           *InstallType
+		previous              *InstallRecord
+		previousChecked       bool
+		removedFiles          []*removedFile
+		postInstallFiles      []string
+		recordSaved           bool
+		running               chan struct{}
+		cancel                context.CancelFunc
+		runLock               sync.Mutex
+		subscribers           []chan Event
+		subscribersLock       sync.Mutex
+		emitLock              sync.Mutex
+		actionLock            sync.Mutex
+		statusLock            sync.Mutex
+		status                *InstallStatus
+		done                  bool
+		progressFunction      func(InstallStatus)
+		config                *Config
+		err                   error
 	}
 )
 
 // NewInstaller creates a new Installer. You will still need to set the target
 // path after initialization:
 //
-// 	installer := NewInstaller()
-// 	/* ... some other stuff happens ... */
-// 	installer.Target = "/some/output/path"
-// 	/* and go: */
-// 	installer.StartInstall()
+//	installer := NewInstaller()
+//	/* ... some other stuff happens ... */
+//	installer.Target = "/some/output/path"
+//	/* and go: */
+//	installer.InstallContext(ctx)
 //
 // Alternatively you can just use NewInstallerTo() and set the target
 // directly:
 //
-// 	installer := NewInstallerTo("/some/output/path/")
-// 	installer.StartInstall()
-// 	/* some watch loop with 'installer.Status()' */
-//
+//	installer := NewInstallerTo("/some/output/path/")
+//	events := installer.Subscribe()
+//	go func() { for event := range events { /* show progress */ } }()
+//	err := installer.InstallContext(ctx)
 func NewInstaller(tempPath string, config *Config) *Installer {
 	return NewInstallerTo("", tempPath, config)
 }
 
 // NewInstallerTo creates a new installer with a target path.
 func NewInstallerTo(target string, tempPath string, config *Config) *Installer {
-	return &Installer{
-		Target:              target,
-		CreateLauncher:      true,
-		Status:              &InstallStatus{},
-		tempPath:            tempPath,
-		doneChannel:         make(chan bool, 1),
-		abortChannel:        make(chan bool, 1),
-		abortConfirmChannel: make(chan bool, 1),
-		progressFunction:    func(status InstallStatus) {},
-		config:              config,
+	installer := &Installer{
+		Target:           target,
+		CreateLauncher:   true,
+		Update:           true,
+		Staged:           config.StagedInstall,
+		status:           &InstallStatus{},
+		tempPath:         tempPath,
+		progressFunction: func(status InstallStatus) {},
+		config:           config,
+	}
+	installer.resetComponents()
+	if len(config.InstallTypes) > 0 {
+		installer.SetInstallType(config.InstallTypes[0].Id)
 	}
+	return installer
 }
 
-// StartInstall runs the installer in a separate goroutine and returns immediately. Use
-// Status() to get updates about the progress.
+// InstallContext installs the files into the target, and returns once they are all
+// installed, or the installation failed or was canceled through ctx. A canceled
+// installation is rolled back (see Rollback()), and returns ErrAborted. The progress is
+// reported to subscribers (see Subscribe()). Run PreInstall() before and PostInstall()
+// after it.
+func (i *Installer) InstallContext(ctx context.Context) error {
+	i.actionLock.Lock()
+	defer i.actionLock.Unlock()
+	err := i.install(ctx)
+	if errors.Is(err, ErrAborted) {
+		i.rollback()
+	}
+	return err
+}
+
+// StartInstall runs InstallContext() in a separate goroutine and returns immediately.
+// Use WaitForDone() to wait for it, and Abort() or Rollback() to cancel it.
 func (i *Installer) StartInstall() {
-	go i.install()
+	ctx, cancel := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	i.runLock.Lock()
+	i.cancel = cancel
+	i.running = running
+	i.runLock.Unlock()
+	go func() {
+		defer close(running)
+		defer cancel()
+		i.InstallContext(ctx)
+	}()
 }
 
-// prepareDataFiles unpacks data.zip into the temp directory and scans the contents.
+// prepareDataFiles opens the archives in the data box and scans the contents. The list
+// of files to install is filtered by the currently selected components. Files in the
+// data box which aren't in one of the payloadFormats are skipped.
+//
+// The archives are read directly from the box, so that files are only ever written to
+// their target location. If the installer is signed, the payload is verified first.
 func (i *Installer) prepareDataFiles() error {
 	if i.dataPrepared {
 		return nil
 	}
-	dataTmpDir := filepath.Join(i.tempPath, "data")
-	err := UnpackDataDir("", dataTmpDir)
-	if err != nil {
-		return err
+	if PayloadSigned() {
+		if err := VerifyPayload(); err != nil {
+			return err
+		}
 	}
-	dataFiles, err := ioutil.ReadDir(dataTmpDir)
+	dataFiles, err := dataFileNames()
 	if err != nil {
 		return err
 	}
-	zipReaders := make([]*zip.ReadCloser, 0, len(dataFiles))
-	totalFileCount := int(0)
-	for _, f := range dataFiles {
-		zipReader, err := zip.OpenReader(filepath.Join(dataTmpDir, f.Name()))
-		if err != nil {
+	payloadReaders := make([]payloadReader, 0, len(dataFiles))
+	for _, name := range dataFiles {
+		payloadReader, ok, err := openPayload(name)
+		if !ok {
+			slog.Warn("Skipping payload, its format is not supported", "payload", name)
 			continue
+		} else if err != nil {
+			slog.Error("Unable to open payload", "payload", name, ErrorAttr(err))
+			return ErrPayloadCorrupt.wrap(err)
 		}
-		zipReaders = append(zipReaders, zipReader)
-		totalFileCount += len(zipReader.File)
+		payloadReaders = append(payloadReaders, payloadReader)
 	}
+	i.setPayloads(payloadReaders)
+	return nil
+}
 
-	i.dataPrepared = false
-	i.totalSize = 0
-	i.files = make([]*InstallFile, 0, totalFileCount)
-	for _, zipReader := range zipReaders {
-		for _, file := range zipReader.File {
+// setPayloads lists the files of all payload archives as the files to install, in
+// payload order, and selects those of the selected components.
+func (i *Installer) setPayloads(payloadReaders []payloadReader) {
+	totalFileCount := 0
+	for _, payloadReader := range payloadReaders {
+		totalFileCount += len(payloadReader.Files())
+	}
+	i.allFiles = make([]*InstallFile, 0, totalFileCount)
+	installFiles := make(map[*PayloadFile]*InstallFile, totalFileCount)
+	for _, payloadReader := range payloadReaders {
+		for _, file := range payloadReader.Files() {
 			// Check for ZipSlip vulnerability and ignore any files with invalid paths.
 			// See: https://snyk.io/research/zip-slip-vulnerability#go
 			dummyTarget := "/some/dir/"
@@ -149,15 +275,16 @@ func (i *Installer) prepareDataFiles() error {
 			) {
 				continue
 			}
-			i.files = append(
-				i.files,
-				&InstallFile{file, file.Name, false},
-			)
-			i.totalSize += int64(file.UncompressedSize64)
+			installFile := &InstallFile{PayloadFile: file, Target: file.Name}
+			if file.hardLink != nil {
+				installFile.hardLink = installFiles[file.hardLink]
+			}
+			installFiles[file] = installFile
+			i.allFiles = append(i.allFiles, installFile)
 		}
 	}
 	i.dataPrepared = true
-	return err
+	i.filterFiles()
 }
 
 // prepareHooks unpacks the hook script directory and sets a flag so repeated calls to
@@ -175,135 +302,542 @@ func (i *Installer) prepareHooks() error {
 
 // install runs the installation. It loops through all files collected by
 // prepareDataFiles, creates directories as necessary and calls installFile on each
-// file.
-func (i *Installer) install() {
-	i.Done = false
-	i.Status = &InstallStatus{}
-	i.actionLock.Lock()
-	defer i.actionLock.Unlock()
+// file. Returns ErrAborted without rolling back if ctx is canceled.
+func (i *Installer) install(ctx context.Context) error {
+	i.statusLock.Lock()
+	i.status = &InstallStatus{}
+	i.done = false
+	i.statusLock.Unlock()
 
 	var err error
 	if !i.dataPrepared {
 		err = i.prepareDataFiles()
 		if err != nil {
-			i.err = err
-			return
+			return i.fail(err)
 		}
 	}
 
-	os.MkdirAll(i.Target, 0755)
-	for _, file := range i.files {
-		select {
-		case <-i.abortChannel:
-			i.Done = false
-			i.abortConfirmChannel <- true
-			i.err = err
-			return
-		default:
-			log.Printf("Installing file/dir %s", i.fileTarget(file))
-			i.Status = &InstallStatus{S: file.Name, File: file}
-			i.progressFunction(*i.Status)
-			if file.FileInfo().IsDir() {
-				os.MkdirAll(i.fileTarget(file), 0755)
-			} else {
-				os.MkdirAll(filepath.Dir(i.fileTarget(file)), 0755)
-				err = i.installFile(file)
+	var resumed *InstallJournal
+	if unfinished := i.UnfinishedInstall(); unfinished != nil {
+		if i.Resume && i.Resumable() {
+			resumed = unfinished
+		} else {
+			i.cleanUpUnfinished(unfinished)
+		}
+	}
+	err = i.restorePrevious()
+	if err != nil {
+		return i.fail(err)
+	}
+	repairing := i.Repairing()
+	updating := !repairing && i.Updating()
+	var previousFiles map[string]InstallRecordFile
+	if repairing {
+		slog.Info("Repairing installation", "target", i.Target)
+		i.selectRecordedFiles(i.repairRecord().fileMap())
+	} else if updating {
+		slog.Info("Updating previous installation", "target", i.Target)
+		previousFiles = i.previous.fileMap()
+	}
+	if resumed != nil && len(resumed.Staging) > 0 {
+		i.staging = resumed.Staging
+		if updating {
+			err = i.carryOverUserFiles(previousFiles)
+		}
+		if err != nil {
+			return i.failStaged(err)
+		}
+	} else if i.Staged && !repairing && resumed == nil && i.canStage(updating) {
+		os.MkdirAll(filepath.Dir(i.Target), 0755)
+		err = i.startStaging()
+		if err == nil && updating {
+			err = i.carryOverUserFiles(previousFiles)
+		}
+		if err != nil {
+			return i.failStaged(err)
+		}
+	} else {
+		if i.Staged {
+			slog.Warn("Unable to stage installation", "target", i.Target)
+		}
+		os.MkdirAll(i.Target, 0755)
+	}
+	if resumed != nil {
+		i.resumeFiles(resumed)
+	}
+	if !repairing {
+		err = i.startJournal(resumed != nil)
+		if err != nil {
+			return i.failStaged(err)
+		}
+	}
+	err = i.installFiles(ctx, updating, repairing, previousFiles)
+	if err == nil {
+		err = i.checkSymlinks()
+	}
+	if err == nil && !i.config.NoVerify {
+		err = i.verifyFiles(ctx)
+	}
+	if errors.Is(err, ErrAborted) {
+		i.err = ErrAborted
+		return err
+	} else if err != nil {
+		return i.failStaged(err)
+	}
+	if len(i.staging) > 0 {
+		i.closeJournal(false)
+		err = i.commitStaging()
+		if err != nil {
+			return i.failStaged(err)
+		}
+	} else if updating {
+		if ctx.Err() != nil {
+			i.err = ErrAborted
+			return ErrAborted
+		}
+		i.removeObsoleteFiles(previousFiles)
+	}
+	i.closeJournal(true)
+	i.err = nil
+	i.statusLock.Lock()
+	i.status = &InstallStatus{Done: true}
+	i.done = true
+	i.statusLock.Unlock()
+	return nil
+}
+
+// installFiles copies all files into the target with a pool of workers (see
+// workerCount()). Directories and symlinks are created and unchanged files are skipped
+// right away, in payload order, while regular files are handed to the workers. So a
+// file's directory always exists before the file is written, and the log lists the
+// files in payload order. Files from sequential archives (see payloadReader) and hard
+// links are installed right away as well. When repairing, intact files are skipped.
+// Files whose checksum is deferred are compared with the existing file only once they
+// are written, see replaceIfChanged().
+//
+// Handing out files stops when ctx is canceled, which returns ErrAborted,
+// or when a file fails, which returns the error of the first failed file in payload
+// order. In both cases the files which are already being written are finished first, so
+// that they can be rolled back.
+func (i *Installer) installFiles(
+	ctx context.Context,
+	updating bool, repairing bool, previousFiles map[string]InstallRecordFile,
+) error {
+	workerCount := i.workerCount()
+	slog.Info("Installing", "target", i.installDir(), "workers", workerCount)
+	i.statusLock.Lock()
+	i.rate.reset(i.installedSize, time.Now())
+	i.statusLock.Unlock()
+	jobs := make(chan int)
+	errs := make([]error, len(i.files))
+	failed := make(chan bool)
+	var failOnce sync.Once
+	var workers sync.WaitGroup
+	for w := 0; w < workerCount; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for f := range jobs {
+				err := i.installRegularFile(i.files[f])
 				if err != nil {
-					i.err = err
-					return
+					errs[f] = err
+					failOnce.Do(func() { close(failed) })
+					continue
 				}
-				i.installedSize += int64(file.UncompressedSize64)
+				i.fileDone(i.files[f], false)
+			}
+		}()
+	}
+	aborted := false
+dispatch:
+	for f, file := range i.files {
+		select {
+		case <-ctx.Done():
+			aborted = true
+			break dispatch
+		case <-failed:
+			break dispatch
+		default:
+		}
+		if file.installed || file.unchanged {
+			continue // resumed
+		}
+		if repairing && !i.deferChecksum(file) && i.fileIntact(file) {
+			i.fileDone(file, true)
+			continue
+		}
+		slog.Debug("Installing file", "phase", PhaseFile, "file", i.fileTarget(file))
+		i.setStatus(InstallStatus{S: file.Name, File: file, Phase: PhaseFile})
+		if file.IsDir() {
+			errs[f] = i.createDir(file)
+			if errs[f] != nil {
+				break dispatch
+			}
+			i.fileDone(file, false)
+		} else if file.symlink() {
+			// Symlinks are created in payload order, so that links to other links can
+			// be checked.
+			if len(i.staging) == 0 {
+				errs[f] = i.backupFile(file)
+			}
+			if errs[f] == nil {
+				i.journalFile(file, journalStarted)
+				errs[f] = i.installSymlink(file)
+			}
+			if errs[f] != nil {
+				break dispatch
+			}
+			i.fileDone(file, false)
+		} else if checksum, ok := i.existingChecksum(
+			file, updating, repairing, previousFiles,
+		); ok {
+			unchanged, err := i.replaceIfChanged(file, checksum)
+			if err != nil {
+				errs[f] = err
+				break dispatch
+			}
+			i.fileDone(file, unchanged)
+		} else if updating && i.fileUnchanged(file, previousFiles) &&
+			(len(i.staging) == 0 || i.linkUnchanged(file)) {
+			slog.Debug("Unchanged file", "phase", PhaseFile, "file", i.fileTarget(file))
+			i.fileDone(file, true)
+		} else if file.sequential() || file.hardLink != nil {
+			// Files of sequential archives are read in archive order anyway, and hard
+			// links need the file they link to.
+			errs[f] = i.installRegularFile(file)
+			if errs[f] != nil {
+				break dispatch
+			}
+			i.fileDone(file, false)
+		} else {
+			errs[f] = i.createParentDir(file)
+			if errs[f] != nil {
+				break dispatch
+			}
+			select {
+			case jobs <- f:
+			case <-failed:
+				break dispatch
 			}
-			file.installed = true
-			i.Status = &InstallStatus{File: file}
 		}
 	}
-	os.RemoveAll(filepath.Join(i.tempPath, "data"))
-	i.Done = true
-	i.Status = &InstallStatus{Done: true}
-	i.doneChannel <- true
+	close(jobs)
+	workers.Wait()
+	if aborted {
+		return ErrAborted
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// workerCount returns the number of files to install at the same time. It is
+// "install_workers" from the config, or the number of CPUs up to
+// defaultInstallWorkersMax.
+func (i *Installer) workerCount() int {
+	if i.config.InstallWorkers > 0 {
+		return i.config.InstallWorkers
+	}
+	if runtime.NumCPU() < defaultInstallWorkersMax {
+		return runtime.NumCPU()
+	}
+	return defaultInstallWorkersMax
+}
+
+// fileDone marks a file as installed, or as unchanged from the previous installation,
+// in the journal as well, and adds the rest of its size that wasn't reported while
+// copying to the progress.
+func (i *Installer) fileDone(file *InstallFile, unchanged bool) {
+	i.statusLock.Lock()
+	defer i.statusLock.Unlock()
+	if unchanged {
+		file.unchanged = true
+		i.journalFile(file, journalUnchanged)
+	} else {
+		file.installed = true
+		i.journalFile(file, journalInstalled)
+	}
+	i.addProgress(int64(file.UncompressedSize64) - file.copied)
+	file.copied = 0
+	i.status = &InstallStatus{File: file}
+}
+
+// fileProgress adds bytes of a file that is being copied to the progress, and emits a
+// ProgressEvent if the last one is at least progressEventInterval ago.
+func (i *Installer) fileProgress(file *InstallFile, bytes int64) {
+	i.statusLock.Lock()
+	file.copied += bytes
+	i.addProgress(bytes)
+	now := time.Now()
+	if now.Sub(i.progressEmitted) < progressEventInterval {
+		i.statusLock.Unlock()
+		return
+	}
+	i.progressEmitted = now
+	progress := i.currentProgress(now)
+	i.statusLock.Unlock()
+	i.emit(ProgressEvent{ByteProgress: progress, File: file})
+}
+
+// progressWriter is an io.Writer that discards what is written, and reports its length
+// as the progress of the file being copied.
+type progressWriter struct {
+	installer *Installer
+	file      *InstallFile
+}
+
+// Write implements io.Writer.
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.installer.fileProgress(w.file, int64(len(p)))
+	return len(p), nil
+}
+
+// phase returns the phase of the installation's current status.
+func (i *Installer) phase() string {
+	i.statusLock.Lock()
+	defer i.statusLock.Unlock()
+	if i.status == nil {
+		return ""
+	}
+	return i.status.Phase
+}
+
+// fail stops the installation with an error, and returns it. The installer is finished,
+// but the installed files are left in place, so that they can still be rolled back. The
+// journal is kept as well, so that the installation can be resumed.
+func (i *Installer) fail(err error) error {
+	slog.Error("Installation failed", "phase", i.phase(), ErrorAttr(err))
+	i.closeJournal(false)
 	i.err = err
+	i.setDone(true)
+	i.setStatus(InstallStatus{Done: true, Phase: PhaseFailed, Err: err})
+	return err
 }
 
-// installFile copies a file into the target location.
-//
-// The file will have the same permissions as the source file, except for read and write
-// permissions for the owning user, which are always given.
+// failStaged stops the installation with an error like fail(), but first removes the
+// staging directory of a staged installation. This leaves the target as it was.
+func (i *Installer) failStaged(err error) error {
+	if len(i.staging) > 0 {
+		i.discardStaging()
+	}
+	return i.fail(err)
+}
+
+// installRegularFile backs up any existing file at the target location of a file, and
+// installs the file or hard link. The file is journaled as started only after the
+// backup, so that a resumed installation can remove it again.
+func (i *Installer) installRegularFile(file *InstallFile) error {
+	if len(i.staging) == 0 {
+		if err := i.backupFile(file); err != nil {
+			return err
+		}
+	}
+	i.journalFile(file, journalStarted)
+	if file.hardLink != nil {
+		return i.installHardLink(file)
+	}
+	return i.installFile(file)
+}
+
+// installFile copies a file from the payload into the target location, see
+// writeFile().
 func (i *Installer) installFile(file *InstallFile) error {
-	targetFile, err := os.OpenFile(
-		i.fileTarget(file),
-		os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
-		file.Mode()|0600, // user has at least read/write
-	)
+	fileReader, err := file.Open()
 	if err != nil {
 		return err
 	}
-	fileReader, err := file.Open()
+	defer fileReader.Close()
+	return i.writeFile(file, fileReader)
+}
+
+// writeFile writes the content of a file from the reader into the target location. The
+// content is written to a hidden file next to the target first (see writePartial()),
+// which is only renamed to the target once it is complete. A file that fails to be
+// written thus never leaves a truncated file at its target, and its partial file is
+// removed.
+func (i *Installer) writeFile(file *InstallFile, fileReader io.Reader) error {
+	partial, err := i.writePartial(file, fileReader)
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(targetFile, fileReader)
-	targetFile.Close()
-	fileReader.Close()
+	err = os.Rename(partial, i.fileTarget(file))
 	if err != nil {
-		return err
+		i.discardPartial(file, partial)
 	}
-	err = os.Chtimes(i.fileTarget(file), time.Now(), file.Modified)
 	return err
 }
 
+// writePartial writes the content of a file from the reader into a hidden file next to
+// its target location (see partialPath()), and returns its path. If that fails, the
+// partial file is removed again.
+//
+// The file's directory must resolve to a path inside the installation directory, so
+// that it can't be written elsewhere through symlinks from the payload (see
+// parentInside()).
+//
+// The file will have the same permissions as the source file, as restricted by the
+// umask, except for read and write permissions for the owning user, which are always
+// given. The setgid and sticky bits are kept as well, but never the setuid bit (see
+// specialModeBits).
+func (i *Installer) writePartial(
+	file *InstallFile, fileReader io.Reader,
+) (string, error) {
+	if _, _, ok := i.parentInside(i.fileTarget(file)); !ok {
+		return "", errParentOutside(file)
+	}
+	partial := partialPath(i.fileTarget(file))
+	targetFile, err := os.OpenFile(
+		partial,
+		os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+		file.Mode().Perm()|0600, // user has at least read/write
+	)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	progress := &progressWriter{installer: i, file: file}
+	_, err = io.Copy(io.MultiWriter(targetFile, hash, progress), fileReader)
+	targetFile.Close()
+	file.sha256 = hex.EncodeToString(hash.Sum(nil))
+	if err == nil {
+		err = os.Chtimes(partial, time.Now(), file.Modified)
+	}
+	if err == nil {
+		err = setSpecialBits(partial, file.Mode())
+	}
+	if err != nil {
+		i.discardPartial(file, partial)
+		return "", err
+	}
+	return partial, nil
+}
+
+// discardPartial removes the partial file of a file that isn't installed after all, and
+// takes its size back out of the progress.
+func (i *Installer) discardPartial(file *InstallFile, partial string) {
+	os.Remove(partial)
+	i.statusLock.Lock()
+	i.addProgress(-file.copied)
+	file.copied = 0
+	i.statusLock.Unlock()
+}
+
+// partialPath returns the hidden path next to a file's target path, under which it is
+// written until it is complete.
+func partialPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+partialFileSuffix)
+}
+
 // fileTarget returns the complete target path of a file, from the installer's Target
-// path and the file's relative Target path.
+// path (or the staging directory, see installDir()) and the file's relative Target
+// path.
 func (i *Installer) fileTarget(file *InstallFile) string {
-	return filepath.Join(i.Target, file.Target)
+	return filepath.Join(i.installDir(), file.Target)
 }
 
-// Abort can be called to stop the installer. The installer will usually not
-// stop immediately, but finish copying the current file.
-//
-// Use Rollback() instead of Abort() if you also want all files and directories
-// rolled back and deleted.
+// Abort cancels an installation started with StartInstall(), and returns once it has
+// stopped. The installer will usually not stop immediately, but finish copying the
+// current files, and then roll back the installation (see Rollback()). Abort may be
+// called any number of times, also after the installation finished.
 func (i *Installer) Abort() {
-	i.abortChannel <- true
-	<-i.abortConfirmChannel
+	i.runLock.Lock()
+	cancel, running := i.cancel, i.running
+	i.runLock.Unlock()
+	if cancel != nil {
+		cancel()
+		<-running
+	}
 }
 
 // Rollback can be used to abort and roll back (i.e. delete) the files and
 // directories that have been installed so far. It will not delete files that
-// haven't been written by the installer, but will delete any file that was
-// overwritten by it.
+// haven't been written by the installer. Files that were overwritten by it are restored
+// from the backup, as are removed files of a previous installation when updating. A
+// staged installation is rolled back by removing the staging directory, or, once it was
+// moved into place, by moving the installation it replaced back.
 //
-// Rollback implicitly calls Abort().
+// Rollback implicitly calls Abort(), and also rolls back an installation that already
+// finished or failed.
 func (i *Installer) Rollback() {
 	i.Abort()
 	i.actionLock.Lock()
 	defer i.actionLock.Unlock()
+	i.statusLock.Lock()
+	rolledBack := i.status.Aborted
+	i.statusLock.Unlock()
+	if !rolledBack {
+		i.rollback()
+	}
+}
+
+// rollback rolls back the installation, see Rollback().
+func (i *Installer) rollback() {
+	i.undoInstall()
+	i.setDone(true)
+	i.setStatus(InstallStatus{Aborted: true, Phase: PhaseRolledBack})
+}
+
+// undoInstall does the work of rollback(), without reporting it. The files created by
+// PostInstall() are removed as well, and the record of the previous installation is
+// restored.
+func (i *Installer) undoInstall() {
+	i.closeJournal(true)
+	if len(i.staging) > 0 {
+		i.discardStaging()
+		return
+	}
+	i.removePostInstallFiles()
+	if i.recordSaved {
+		i.restoreInstallRecord()
+	}
+	if i.committed {
+		i.rollbackCommitted()
+		return
+	}
+	i.restoreRemovedFiles()
 	// Do not os.RemoveAll(i.Target)! That could easily delete files and
 	// folders not created by the installer.
 	for p := len(i.files) - 1; p >= 0; p-- {
 		if i.files[p].installed {
 			err := os.Remove(i.fileTarget(i.files[p]))
 			if err != nil {
-				log.Printf("Error deleting %s\n", i.fileTarget(i.files[p]))
+				slog.Warn(
+					"Unable to roll back file", "phase", PhaseRolledBack,
+					"file", i.fileTarget(i.files[p]), ErrorAttr(err),
+				)
 			} else {
-				log.Printf("Rolled back: %s\n", i.fileTarget(i.files[p]))
+				slog.Debug(
+					"Rolled back file", "phase", PhaseRolledBack,
+					"file", i.fileTarget(i.files[p]),
+				)
 			}
+			i.statusLock.Lock()
 			i.files[p].installed = false
-			if !i.files[p].FileInfo().IsDir() {
+			if !i.files[p].IsDir() {
 				i.installedSize -= int64(i.files[p].UncompressedSize64)
 			}
-			i.Status = &InstallStatus{File: i.files[p]}
+			i.status = &InstallStatus{File: i.files[p]}
+			i.statusLock.Unlock()
+		} else if i.files[p].unchanged {
+			i.statusLock.Lock()
+			i.files[p].unchanged = false
+			i.installedSize -= int64(i.files[p].UncompressedSize64)
+			i.statusLock.Unlock()
 		}
+		// restore overwritten files, also those that failed to install
+		i.restoreBackup(i.files[p])
 	}
-	i.Done = true
-	i.doneChannel <- true
-	i.Status = &InstallStatus{Aborted: true}
+	i.discardBackup()
 }
 
 // CheckSetInstallDir checks if the given directory is a valid, writable path. If it is
 // it sets it as the installer's target directory. Returns err when the installPath
 // exists but is not a directory, or when installPath (or the nearest existing parent)
-// is not writable.
+// is not writable. If Elevate is set, the path doesn't need to be writable for the
+// current user.
 func (i *Installer) CheckSetInstallDir(installPath string) error {
 	parent := path.Clean(installPath)
 	for parent != string(os.PathSeparator) && parent != "." {
@@ -312,12 +846,12 @@ func (i *Installer) CheckSetInstallDir(installPath string) error {
 			if os.IsNotExist(err) {
 				parent = path.Dir(parent)
 			} else {
-				return errors.New("path_err_other")
+				return errPathOther.wrap(err)
 			}
 		} else if !parentInfo.IsDir() {
-			return errors.New("path_err_not_dir")
-		} else if !osFileWriteAccess(parent) { // os-specific
-			return errors.New("path_err_not_writable")
+			return ErrPathInvalid
+		} else if !i.Elevate && !osFileWriteAccess(parent) { // os-specific
+			return ErrPathNotWritable
 		} else {
 			break
 		}
@@ -330,8 +864,10 @@ func (i *Installer) CheckSetInstallDir(installPath string) error {
 // NextFile returns the file that the installer will install next, or the one that is
 // currently being installed.
 func (i *Installer) NextFile() *InstallFile {
+	i.statusLock.Lock()
+	defer i.statusLock.Unlock()
 	for _, file := range i.files {
-		if !file.installed {
+		if !file.installed && !file.unchanged {
 			return file
 		}
 	}
@@ -339,7 +875,8 @@ func (i *Installer) NextFile() *InstallFile {
 }
 
 // SetProgressFunction takes a function which receives an InstallStatus, and calls it
-// every time right before the installer starts to copy a file or directory.
+// every time right before the installer starts to copy a file or directory, as well as
+// at the start or end of every other phase of the installation (see InstallStatus).
 func (i *Installer) SetProgressFunction(function func(InstallStatus)) {
 	i.progressFunction = function
 }
@@ -347,12 +884,32 @@ func (i *Installer) SetProgressFunction(function func(InstallStatus)) {
 // Progress returns the size ratio between already installed files and all files. The
 // result is a float between 0.0 and 1.0, inclusive.
 func (i *Installer) Progress() float64 {
+	i.statusLock.Lock()
+	defer i.statusLock.Unlock()
 	if i.totalSize == 0 {
 		return 0.0
 	}
 	return float64(i.installedSize) / float64(i.totalSize)
 }
 
+// Rate returns the current throughput of the installation in bytes per second, as a
+// moving average over the last few seconds. It is 0 until the installation has run for
+// a moment.
+func (i *Installer) Rate() int64 {
+	i.statusLock.Lock()
+	defer i.statusLock.Unlock()
+	return i.currentProgress(time.Now()).Rate
+}
+
+// ETA returns the estimated time until all files are installed, at the current Rate().
+// ok is false if the rate isn't known yet.
+func (i *Installer) ETA() (eta time.Duration, ok bool) {
+	i.statusLock.Lock()
+	defer i.statusLock.Unlock()
+	progress := i.currentProgress(time.Now())
+	return progress.ETA, progress.Rate > 0
+}
+
 // diskSpace returns the user-available disk space in bytes, for the currently selected
 // installer target path.
 func (i *Installer) diskSpace() int64 {
@@ -360,28 +917,41 @@ func (i *Installer) diskSpace() int64 {
 	return osDiskSpace(i.existingTargetParent)
 }
 
-// DiskSpaceSufficient returns true when the total size of files to be installed is
-// smaller than the remaining available space on the disk that contains the installer's
-// target path.
+// DiskSpaceSufficient returns true when the total size of files to be installed, plus
+// the free space required by the config, is smaller than the remaining available space
+// on the disk that contains the installer's target path.
 func (i *Installer) DiskSpaceSufficient() bool {
-	return i.totalSize < i.diskSpace()
+	return i.spaceRequired() < i.diskSpace()
+}
+
+// spaceRequired returns the disk space in bytes needed for the installation, which is
+// the total size of the files plus the free space required by the config.
+func (i *Installer) spaceRequired() int64 {
+	return i.totalSize + i.config.Requirements.freeSpace()
 }
 
 // SizeString returns a human-readable string denoting the total size of all files
 // contained in the installer, appending a size suffix as needed.
 func (i *Installer) SizeString() string {
 	i.prepareDataFiles()
-	return i.sizeString(i.totalSize)
+	return sizeString(i.totalSize)
+}
+
+// SpaceRequiredString returns a human-readable string denoting the disk space needed
+// for the installation, including the free space required by the config.
+func (i *Installer) SpaceRequiredString() string {
+	i.prepareDataFiles()
+	return sizeString(i.spaceRequired())
 }
 
 // SpaceString returns a human-readable string denoting the remaining available space on
 // the currently selected installer target path.
-func (i *Installer) SpaceString() string { return i.sizeString(i.diskSpace()) }
+func (i *Installer) SpaceString() string { return sizeString(i.diskSpace()) }
 
 // sizeString returns a human-redable string representation of the given amount of
 // bytes. Every power of 1024 is shortened to its IEC prefix (*not SI*!), i.e. 2000
 // bytes become the string "1.95 KiB", and 5242880 bytes becomes "5.00 MiB".
-func (i *Installer) sizeString(bytes int64) string {
+func sizeString(bytes int64) string {
 	switch {
 	case bytes < KiB:
 		return fmt.Sprintf("%d B", bytes)
@@ -398,71 +968,148 @@ func (i *Installer) sizeString(bytes int64) string {
 	}
 }
 
-// WaitForDone returns only after the installer has finished installing (or
-// rolling back).
+// WaitForDone returns only after an installation started with StartInstall() has
+// finished installing (or rolling back).
 func (i *Installer) WaitForDone() {
-	for {
-		if done := <-i.doneChannel; done {
-			return
-		}
+	i.runLock.Lock()
+	running := i.running
+	i.runLock.Unlock()
+	if running != nil {
+		<-running
 	}
 }
 
 // PreInstall runs a pre-install script, if a file hooks/pre-install.* exists in the
 // resource directory. The file extension is OS-specific (.sh for Linux, .bat for
-// Windows).
-func (i *Installer) PreInstall() {
-	i.prepareHooks()
-	i.Status = &InstallStatus{S: "pre"}
-	err := osRunHookIfExists(
-		filepath.Join(i.tempPath, "hooks", "pre-install"),
-		i.Target,
-	)
+// Windows). Returns ErrHookFailed if the script fails, which also finishes the
+// installation.
+func (i *Installer) PreInstall(variablesList ...VariableMap) error {
+	i.setStatus(InstallStatus{S: "pre", Phase: PhasePreInstall})
+	err := i.runHook("pre-install", i.variables(variablesList...))
 	if err != nil {
 		i.err = err
+		i.setStatus(InstallStatus{S: "pre", Phase: PhasePreInstall, Err: err})
+		i.setStatus(InstallStatus{Done: true, Phase: PhaseFailed, Err: err})
 	}
+	return err
 }
 
 // PostInstall runs a post-install script & creates an uninstaller as well as an
-// optional launcher entry for the program.
-func (i *Installer) PostInstall(variablesList ...VariableMap) {
-	i.Status = &InstallStatus{S: "post"}
+// optional launcher entry and desktop shortcut for the program. It also writes the
+// install record used to detect this installation later on. Files backed up during the
+// installation are discarded if the post-install script succeeds. If it fails, the
+// installation is rolled back, together with the files created here (see Rollback()),
+// and ErrHookFailed is returned.
+func (i *Installer) PostInstall(variablesList ...VariableMap) error {
 	var err error
-	uninstallerFileList := make([]string, 0, len(i.files)+1) // +1 for launcher shortcut
-	// reversed -> delete dir content before dir
-	for j := len(i.files) - 1; j >= 0; j-- {
-		if i.files[j].installed {
-			uninstallerFileList = append(uninstallerFileList, i.fileTarget(i.files[j]))
+	launcherFiles := make([]string, 0, 2)
+	variables := i.variables(variablesList...)
+	// Launchers of a previous installation have the same name, and are kept by a
+	// rollback.
+	keepLaunchers := i.PreviousInstall() != nil
+	if i.CreateLauncher && i.LauncherAvailable() {
+		launcherFile, err := osCreateLauncherEntry(variables)
+		if err == nil {
+			launcherFiles = append(launcherFiles, launcherFile)
+			if !keepLaunchers {
+				i.postInstallFiles = append(i.postInstallFiles, launcherFile)
+			}
+		} else {
+			slog.Warn(
+				"Unable to create launcher", "phase", PhaseLauncher, ErrorAttr(err),
+			)
 		}
+		i.setStatus(InstallStatus{S: launcherFile, Phase: PhaseLauncher, Err: err})
 	}
-	variablesList = append(variablesList, VariableMap{"installDir": i.Target})
-	variables := MergeVariables(variablesList...)
-	if i.StartCommandAvailable() && i.CreateLauncher {
-		launcherFile, err := osCreateLauncherEntry(variables)
+	if i.CreateDesktopShortcut && i.LauncherAvailable() {
+		shortcutFile, err := osCreateDesktopShortcut(variables)
 		if err == nil {
-			uninstallerFileList = append(uninstallerFileList, launcherFile)
-			err = osCreateUninstaller(uninstallerFileList, variables)
-			if err != nil {
-				log.Println(err.Error())
+			launcherFiles = append(launcherFiles, shortcutFile)
+			if !keepLaunchers {
+				i.postInstallFiles = append(i.postInstallFiles, shortcutFile)
 			}
 		} else {
-			log.Println(err.Error())
+			slog.Warn(
+				"Unable to create desktop shortcut", "phase", PhaseDesktopShortcut,
+				ErrorAttr(err),
+			)
 		}
+		i.setStatus(InstallStatus{
+			S: shortcutFile, Phase: PhaseDesktopShortcut, Err: err,
+		})
+	}
+	i.backupUninstaller(variables)
+	uninstallerFile, err := i.createUninstaller(launcherFiles, variables)
+	i.postInstallFiles = append(
+		i.postInstallFiles,
+		uninstallerFile, filepath.Join(i.Target, uninstallManifestFilename),
+	)
+	if err != nil {
+		slog.Warn(
+			"Unable to create uninstaller", "phase", PhaseUninstaller, ErrorAttr(err),
+		)
+	}
+	i.setStatus(InstallStatus{S: uninstallerFile, Phase: PhaseUninstaller, Err: err})
+	err = i.newInstallRecord(uninstallerFile).save()
+	if err != nil {
+		slog.Warn("Unable to write install record", ErrorAttr(err))
 	} else {
-		err = osCreateUninstaller(uninstallerFileList, variables)
+		i.recordSaved = true
+	}
+	i.setStatus(InstallStatus{S: "post", Phase: PhasePostInstall})
+	err = i.runHook("post-install", variables)
+	if err != nil {
+		slog.Info(
+			"Rolling back installation", "phase", PhasePostInstall, ErrorAttr(err),
+		)
+		i.undoInstall()
+		i.err = err
+		i.setStatus(InstallStatus{S: "post", Phase: PhasePostInstall, Err: err})
+		i.setStatus(InstallStatus{Done: true, Phase: PhaseFailed, Err: err})
+		return err
+	}
+	i.discardBackup()
+	i.discardReplaced()
+	i.postInstallFiles = nil
+	i.recordSaved = false
+	i.copyLog()
+	i.setStatus(InstallStatus{Done: true, Phase: PhaseDone})
+	return nil
+}
+
+// variables merges the given variable maps and adds the installer's own variables, the
+// installation directory and the chosen installation type.
+func (i *Installer) variables(variablesList ...VariableMap) VariableMap {
+	variablesList = append(variablesList, VariableMap{
+		"installDir":  i.Target,
+		"installType": i.installTypeId(),
+	})
+	return MergeVariables(variablesList...)
+}
+
+// runHook runs the hook script with the given name, unless the chosen installation type
+// excludes it. Template variables in the script are expanded before it is run.
+func (i *Installer) runHook(name string, variables VariableMap) error {
+	if !i.runsHook(name) {
+		slog.Info("Skipping hook", "hook", name, "installType", i.installTypeId())
+		return nil
+	}
+	i.prepareHooks()
+	scriptFile := filepath.Join(i.tempPath, "hooks", name)
+	scripts, _ := filepath.Glob(scriptFile + ".*")
+	for _, script := range scripts {
+		err := ExpandFileVariables(script, variables)
 		if err != nil {
-			log.Println(err.Error())
+			return ErrHookFailed.wrap(err)
 		}
 	}
-	i.prepareHooks()
-	err = osRunHookIfExists(
-		filepath.Join(i.tempPath, "hooks", "post-install"),
-		i.Target,
-	)
+	output := &hookOutput{installer: i, hook: name}
+	err := osRunHookIfExists(scriptFile, i.Target, output) // os-specific
+	output.flush()
 	if err != nil {
-		i.err = err
-		return
+		return ErrHookFailed.wrap(err)
 	}
+	return nil
 }
 
 // StartCommandAvailable is queried when deciding whether to install an application-
@@ -3,21 +3,31 @@
 package linux_installer
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"golang.org/x/sys/unix"
 )
 
 const (
+	installRecordUserDir    = ".local/share/linux_installer/installs"
+	installRecordSystemDir  = "/var/lib/linux_installer/installs"
 	desktopFileUserDir      = ".local/share/applications"
 	desktopFileSystemDir    = "/usr/share/applications"
+	allUsersInstallDir      = "/opt"
+	desktopDirDefault       = "Desktop"
+	userDirsFilename        = "user-dirs.dirs"
 	desktopFilenameTemplate = `{{if .organization_short}}{{.organization_short | lower | replace " " ""}}-{{end}}{{.product | lower | replace " " ""}}.desktop`
 	desktopFileTemplate     = `[Desktop Entry]
 Name={{.product}}
@@ -30,6 +40,14 @@ Terminal={{.show_terminal_during_app_run}}
 `
 )
 
+var (
+	// libraryDirs are the usual directories of shared libraries.
+	libraryDirs = []string{"/lib", "/lib64", "/usr/lib", "/usr/lib64", "/usr/local/lib"}
+	// libraryDirPatterns match the multiarch directories of shared libraries, e.g.
+	// /usr/lib/x86_64-linux-gnu on Debian.
+	libraryDirPatterns = []string{"/lib/*-linux-gnu*", "/usr/lib/*-linux-gnu*"}
+)
+
 // osFileWriteAccess returns whether a given path has write access for the current user.
 func osFileWriteAccess(path string) bool {
 	return unix.Access(path, unix.W_OK) == nil
@@ -45,6 +63,36 @@ func osDiskSpace(path string) int64 {
 	return int64(fs.Bavail) * fs.Bsize
 }
 
+// osInstallRecordDir returns the directory in which install records are kept.
+//
+// On Linux this is a directory in $XDG_DATA_HOME (usually ~/.local/share) or—if
+// installing as root—in /var/lib.
+func osInstallRecordDir() string {
+	usr, err := user.Current()
+	if err == nil && usr.Uid == "0" {
+		return installRecordSystemDir
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dataHome) {
+		return filepath.Join(dataHome, "linux_installer", "installs")
+	}
+	if err != nil {
+		return filepath.Join(os.Getenv("HOME"), installRecordUserDir)
+	}
+	return filepath.Join(usr.HomeDir, installRecordUserDir)
+}
+
+// osInstallRecordDirs returns all directories in which install records are kept, the
+// current user's directory first.
+//
+// On Linux these are the user's records directory and the system-wide one in /var/lib.
+func osInstallRecordDirs() []string {
+	recordDir := osInstallRecordDir()
+	if recordDir == installRecordSystemDir {
+		return []string{recordDir}
+	}
+	return []string{recordDir, installRecordSystemDir}
+}
+
 // osCreateLauncherEntry creates an application menu entry for the application being
 // installed.
 //
@@ -57,63 +105,107 @@ func osCreateLauncherEntry(variables VariableMap) (desktopFilepath string, err e
 	if err != nil {
 		return
 	}
-	var applicationsDir string
-	if usr.Uid == "0" {
-		applicationsDir = desktopFileSystemDir
-	} else {
-		applicationsDir = desktopFileUserDir
+	applicationsDir := desktopFileSystemDir
+	if usr.Uid != "0" {
+		applicationsDir = filepath.Join(usr.HomeDir, desktopFileUserDir)
 	}
-	err = os.MkdirAll(filepath.Join(usr.HomeDir, applicationsDir), 0755)
+	err = os.MkdirAll(applicationsDir, 0755)
 	if err != nil {
 		return
 	}
-	desktopFilepath = filepath.Join(usr.HomeDir, applicationsDir, desktopFilename)
+	desktopFilepath = filepath.Join(applicationsDir, desktopFilename)
 	err = ioutil.WriteFile(desktopFilepath, []byte(content), 0755)
 	return
 }
 
-// osCreateUninstaller expands the uninstaller template with installed files that were
-// installed, and writes the result into a file that removes the installed application
-// when executed.
+// osCreateDesktopShortcut creates a shortcut on the user's desktop for the application
+// being installed.
 //
-// The uninstaller script template(s) are located in the resources/uninstaller/
-// directory.
-//
-// On Linux, this is a simple .sh script with a list of files and directories to be fed
-// to rm and rmdir respectively.
-func osCreateUninstaller(installedFiles []string, variables VariableMap) error {
-	uninstallScriptFilepath := filepath.Join(
-		variables["installDir"], variables["uninstaller_name"]+".sh",
-	)
-	uninstallScriptTemplate, err := GetResource("uninstaller/uninstall.sh.template")
+// On Linux this creates the same .desktop file as osCreateLauncherEntry inside the XDG
+// desktop directory, and marks it as trusted so that desktops like GNOME allow
+// launching it.
+func osCreateDesktopShortcut(variables VariableMap) (desktopFilepath string, err error) {
+	content := ExpandVariables(desktopFileTemplate, variables)
+	desktopFilename := ExpandVariables(desktopFilenameTemplate, variables)
+	desktopDir, err := xdgDesktopDir()
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(desktopDir, 0755)
+	if err != nil {
+		return
+	}
+	desktopFilepath = filepath.Join(desktopDir, desktopFilename)
+	err = ioutil.WriteFile(desktopFilepath, []byte(content), 0755)
 	if err != nil {
-		return err
+		return
+	}
+	out, trustErr := exec.Command(
+		"gio", "set", desktopFilepath, "metadata::trusted", "true",
+	).CombinedOutput()
+	if trustErr != nil {
+		slog.Warn(
+			"Unable to mark desktop shortcut as trusted", "phase", PhaseDesktopShortcut,
+			"output", string(out), ErrorAttr(trustErr),
+		)
 	}
-	installedFiles = append(installedFiles, uninstallScriptFilepath)
-	content := ExpandAllVariables(
-		uninstallScriptTemplate,
-		variables,
-		UntypedVariableMap{"installedFiles": installedFiles},
-	)
-	return ioutil.WriteFile(uninstallScriptFilepath, []byte(content), 0755)
+	return
+}
+
+// xdgDesktopDir returns the user's desktop directory, as configured in
+// $XDG_CONFIG_HOME/user-dirs.dirs (see xdg-user-dirs). If it isn't configured there,
+// then the "Desktop" directory in the user's home is returned.
+func xdgDesktopDir() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if !filepath.IsAbs(configHome) {
+		configHome = filepath.Join(usr.HomeDir, ".config")
+	}
+	userDirsFile, err := os.Open(filepath.Join(configHome, userDirsFilename))
+	if err != nil {
+		return filepath.Join(usr.HomeDir, desktopDirDefault), nil
+	}
+	defer userDirsFile.Close()
+	scanner := bufio.NewScanner(userDirsFile)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "XDG_DESKTOP_DIR=") {
+			continue
+		}
+		value, err := strconv.Unquote(strings.TrimPrefix(line, "XDG_DESKTOP_DIR="))
+		if err != nil {
+			break
+		}
+		value = strings.Replace(value, "$HOME", usr.HomeDir, 1)
+		if filepath.IsAbs(value) {
+			return value, nil
+		}
+	}
+	return filepath.Join(usr.HomeDir, desktopDirDefault), nil
 }
 
 // osRunHookIfExists runs a script given its base name (no extension), if that script
 // does exist. The hook scripts are located in the resources/hooks/ directory.
 // installPath is the installation directory, and the script can expect it as its first
-// commandline argument.
+// commandline argument. The script's output is also written to output while it runs.
 //
 // On Linux it loads the hook files that end in ".sh".
-func osRunHookIfExists(scriptFile string, installPath string) error {
+func osRunHookIfExists(scriptFile string, installPath string, output io.Writer) error {
 	if _, err := os.Stat(scriptFile + ".sh"); os.IsNotExist(err) {
 		return nil
 	}
 	err := os.Chmod(scriptFile+".sh", 0755)
-	out, err := exec.Command("/bin/sh", scriptFile+".sh", installPath).CombinedOutput()
-	log.Println("hook output:\n", string(out[:]))
+	var out bytes.Buffer
+	cmd := exec.Command("/bin/sh", scriptFile+".sh", installPath)
+	cmd.Stdout = io.MultiWriter(&out, output)
+	cmd.Stderr = cmd.Stdout
+	err = cmd.Run()
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			return errors.New(string(exitErr.Stderr))
+			return errors.New(exitErr.Error() + "\n" + strings.TrimSpace(out.String()))
 		} else {
 			return err
 		}
@@ -136,12 +228,142 @@ func osShowRawErrorDialog(message string) (err error) {
 	return
 }
 
+// osExchangeDirs atomically exchanges two directories on the same filesystem, so that
+// each path afterwards refers to the other directory.
+//
+// On Linux this uses renameat2() with RENAME_EXCHANGE, which needs kernel 3.15 and
+// isn't supported by all filesystems.
+func osExchangeDirs(a string, b string) error {
+	return unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE)
+}
+
+// osSameFilesystem returns whether two existing paths are on the same filesystem.
+func osSameFilesystem(a string, b string) bool {
+	var statA, statB unix.Stat_t
+	if unix.Stat(a, &statA) != nil || unix.Stat(b, &statB) != nil {
+		return false
+	}
+	return statA.Dev == statB.Dev
+}
+
+// osMachine returns the CPU architecture as printed by "uname -m", e.g. "x86_64", or an
+// empty string if it is unknown.
+func osMachine() string {
+	var uname unix.Utsname
+	if unix.Uname(&uname) != nil {
+		return ""
+	}
+	return unix.ByteSliceToString(uname.Machine[:])
+}
+
+// osKernelVersion returns the kernel release, e.g. "5.4.0-42-generic", or an empty
+// string if it is unknown.
+func osKernelVersion() string {
+	var uname unix.Utsname
+	if unix.Uname(&uname) != nil {
+		return ""
+	}
+	return unix.ByteSliceToString(uname.Release[:])
+}
+
+// osGlibcVersion returns the version of the GNU C library, e.g. "2.31", or an empty
+// string if it is unknown.
+func osGlibcVersion() string {
+	out, err := exec.Command("getconf", "GNU_LIBC_VERSION").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(out)), "glibc ")
+}
+
+// osLibraryAvailable returns whether a shared library with the given file name, e.g.
+// "libGL.so.1", is installed. It is looked for in $LD_LIBRARY_PATH and the usual
+// library directories, and in the dynamic linker's cache.
+func osLibraryAvailable(name string) bool {
+	dirs := filepath.SplitList(os.Getenv("LD_LIBRARY_PATH"))
+	dirs = append(dirs, libraryDirs...)
+	for _, pattern := range libraryDirPatterns {
+		matches, _ := filepath.Glob(pattern)
+		dirs = append(dirs, matches...)
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	ldconfig, err := exec.LookPath("ldconfig")
+	if err != nil {
+		ldconfig = "/sbin/ldconfig"
+	}
+	out, err := exec.Command(ldconfig, "-p").Output()
+	return err == nil && bytes.Contains(out, []byte("\t"+name+" "))
+}
+
+// osMemory returns the total amount of RAM in bytes, or 0 if it is unknown.
+func osMemory() int64 {
+	var info unix.Sysinfo_t
+	if unix.Sysinfo(&info) != nil {
+		return 0
+	}
+	return int64(info.Totalram) * int64(info.Unit)
+}
+
+// osStateDir returns the user's directory for application state, $XDG_STATE_HOME or
+// ~/.local/state.
+func osStateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state"), nil
+}
+
+// osIsRoot returns whether the installer runs with administrator rights.
+func osIsRoot() bool { return os.Geteuid() == 0 }
+
+// osElevationCommand returns the command that runs another command with administrator
+// rights, or nil if there is none. With gui, the command has to ask for the password
+// graphically.
+//
+// On Linux this is pkexec, which asks through the desktop's polkit agent, or in the
+// terminal sudo if available.
+func osElevationCommand(gui bool) []string {
+	commands := []string{"sudo", "pkexec"}
+	if gui {
+		if len(os.Getenv("DISPLAY")) == 0 && len(os.Getenv("WAYLAND_DISPLAY")) == 0 {
+			return nil
+		}
+		commands = []string{"pkexec"}
+	}
+	for _, command := range commands {
+		if path, err := exec.LookPath(command); err == nil {
+			return []string{path}
+		}
+	}
+	return nil
+}
+
+// osAllUsersInstallDir returns the directory in which applications are usually
+// installed for all users.
+//
+// On Linux this is /opt.
+func osAllUsersInstallDir() string { return allUsersInstallDir }
+
+// osTryLock locks an open file exclusively, unless it is already locked, which returns
+// false. The lock is released when the file is closed, or the process ends.
+func osTryLock(file *os.File) bool {
+	return unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB) == nil
+}
+
 // osExecVE runs cmd with the given args, replaces the current process and never
 // returns.
 func osExecVE(cmd string, args []string) {
 	err := syscall.Exec(cmd, args, os.Environ())
 	if err != nil {
-		log.Println("execve error:", err.Error())
+		slog.Error("Unable to run installed application", "cmd", cmd, ErrorAttr(err))
 		os.Exit(1)
 	}
 }
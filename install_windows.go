@@ -5,8 +5,10 @@ package linux_installer
 // This code is unused & untested!! (And probably completely unnecessary...)
 
 import (
+	"bytes"
 	"errors"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -47,22 +49,32 @@ func osDiskSpace(path string) (availableBytes int64) {
 	return
 }
 
+func osInstallRecordDir() string {
+	return filepath.Join(os.Getenv("APPDATA"), "linux_installer", "installs")
+}
+func osInstallRecordDirs() []string {
+	return []string{osInstallRecordDir()}
+}
+
 func osCreateLauncherEntry(variables VariableMap) (desktopFilepath string, err error) {
 	return
 }
-func osCreateUninstaller(uninstallerFileList []string, variables VariableMap) error {
-	return nil
+func osCreateDesktopShortcut(variables VariableMap) (desktopFilepath string, err error) {
+	return
 }
 
-func osRunHookIfExists(scriptFile string, installPath string) (err error) {
+func osRunHookIfExists(scriptFile string, installPath string, output io.Writer) (err error) {
 	if _, err = os.Stat(scriptFile + ".bat"); os.IsNotExist(err) {
 		return
 	}
-	out, err := exec.Command(scriptFile+".bat", installPath).Output()
-	log.Println("hook output:\n", string(out[:]))
+	var out, stderr bytes.Buffer
+	cmd := exec.Command(scriptFile+".bat", installPath)
+	cmd.Stdout = io.MultiWriter(&out, output)
+	cmd.Stderr = io.MultiWriter(&stderr, output)
+	err = cmd.Run()
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return errors.New(string(exitErr.Stderr))
+		if _, ok := err.(*exec.ExitError); ok {
+			return errors.New(stderr.String())
 		} else {
 			return err
 		}
@@ -70,14 +82,59 @@ func osRunHookIfExists(scriptFile string, installPath string) (err error) {
 	return err
 }
 
+func osExchangeDirs(a string, b string) error {
+	return errors.New("exchanging directories is not supported")
+}
+
+func osSameFilesystem(a string, b string) bool {
+	return false
+}
+
 func osShowRawErrorDialog(message string) (err error) { return }
 
+// osMachine is not implemented on Windows, so the architecture requirement is not
+// checked.
+func osMachine() string { return "" }
+
+// osKernelVersion is not implemented on Windows, so the kernel requirement is not
+// checked.
+func osKernelVersion() string { return "" }
+
+// osGlibcVersion returns an empty string, since there is no glibc on Windows.
+func osGlibcVersion() string { return "" }
+
+// osLibraryAvailable is not implemented on Windows, so all libraries count as
+// available.
+func osLibraryAvailable(name string) bool { return true }
+
+// osMemory is not implemented on Windows, so the memory requirement is not checked.
+func osMemory() int64 { return 0 }
+
+// osStateDir returns the user's local application data directory, %LocalAppData%.
+func osStateDir() (string, error) {
+	return os.UserCacheDir()
+}
+
+// osIsRoot is not implemented on Windows, and always returns false.
+func osIsRoot() bool { return false }
+
+// osElevationCommand is not implemented on Windows, so installing for all users is not
+// available.
+func osElevationCommand(gui bool) []string { return nil }
+
+// osAllUsersInstallDir returns the directory for programs, %ProgramFiles%.
+func osAllUsersInstallDir() string { return os.Getenv("ProgramFiles") }
+
+// osTryLock is not implemented on Windows, so stale temporary directories are never
+// removed.
+func osTryLock(file *os.File) bool { return false }
+
 // osExecVE emulates Linux execve in that it starts a new process and then terminates
 // the current one (and thus never returns).
 func osExecVE(cmd string, args []string) {
 	_, _, err := syscall.StartProcess(cmd, args, nil)
 	if err != nil {
-		log.Println("execve error:", err.Error())
+		slog.Error("Unable to run installed application", "cmd", cmd, ErrorAttr(err))
 		os.Exit(1)
 	} else {
 		os.Exit(0)
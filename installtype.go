@@ -0,0 +1,75 @@
+package linux_installer
+
+import (
+	"log/slog"
+)
+
+// CreatesLauncher returns whether a launcher entry is created for this installation
+// type, which is the case unless Launcher is explicitly set to false.
+func (t *InstallType) CreatesLauncher() bool {
+	return t.Launcher == nil || *t.Launcher
+}
+
+// runsHook returns whether the hook with the given name runs for this installation type.
+func (t *InstallType) runsHook(name string) bool {
+	if len(t.Hooks) == 0 {
+		return true
+	}
+	for _, hook := range t.Hooks {
+		if hook == name {
+			return true
+		}
+	}
+	return false
+}
+
+// InstallTypes returns the list of all installation types defined in the config.
+func (i *Installer) InstallTypes() []InstallType {
+	return i.config.InstallTypes
+}
+
+// InstallType returns the currently chosen installation type, or nil if there are no
+// installation types defined in the config.
+func (i *Installer) InstallType() *InstallType {
+	return i.installType
+}
+
+// SetInstallType chooses the installation type with the given ID, and selects its
+// components. Returns an error if there is no installation type with the given ID.
+func (i *Installer) SetInstallType(id string) error {
+	for t := range i.config.InstallTypes {
+		if i.config.InstallTypes[t].Id != id {
+			continue
+		}
+		i.installType = &i.config.InstallTypes[t]
+		if len(i.installType.Components) > 0 {
+			return i.SetComponents(i.installType.Components)
+		}
+		i.resetComponents()
+		i.filterFiles()
+		return nil
+	}
+	slog.Error("Unknown installation type", "installType", id)
+	return errTypeUnknown
+}
+
+// installTypeId returns the ID of the chosen installation type, or an empty string.
+func (i *Installer) installTypeId() string {
+	if i.installType == nil {
+		return ""
+	}
+	return i.installType.Id
+}
+
+// runsHook returns whether the hook with the given name runs for the chosen installation
+// type.
+func (i *Installer) runsHook(name string) bool {
+	return i.installType == nil || i.installType.runsHook(name)
+}
+
+// LauncherAvailable returns whether a launcher entry or desktop shortcut can be created,
+// which depends on the chosen installation type and whether there is a start command.
+func (i *Installer) LauncherAvailable() bool {
+	return i.StartCommandAvailable() &&
+		(i.installType == nil || i.installType.CreatesLauncher())
+}
@@ -0,0 +1,297 @@
+package linux_installer
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// journalFilename is the name of the journal inside the installation (or staging)
+// directory. It lists the files that an installation started and finished writing, and
+// only exists while the files are installed. A journal found later belongs to an
+// installation that was interrupted, e.g. by a crash or power loss.
+const journalFilename = ".installer-journal"
+
+// States of a file in the journal.
+const (
+	journalStarted   = "started"
+	journalInstalled = "installed"
+	journalUnchanged = "unchanged"
+)
+
+type (
+	// InstallJournal describes an unfinished installation, from the journal it left in
+	// its installation directory. The first line of the journal holds the installation's
+	// choices, each further line is a journalEntry.
+	//
+	// Staging is the staging directory of a staged installation, which contains the
+	// journal.
+	InstallJournal struct {
+		Product     string    `json:"product"`
+		Version     string    `json:"version"`
+		Target      string    `json:"target"`
+		Staging     string    `json:"staging,omitempty"`
+		Started     time.Time `json:"started"`
+		Update      bool      `json:"update,omitempty"`
+		InstallType string    `json:"install_type,omitempty"`
+		Components  []string  `json:"components,omitempty"`
+
+		entries []journalEntry
+		dir     string
+	}
+	// journalEntry is a file whose installation started or finished. Path is relative
+	// to the installation directory.
+	journalEntry struct {
+		Path  string `json:"path"`
+		State string `json:"state"`
+	}
+	// journalWriter appends entries to the journal of the running installation.
+	journalWriter struct {
+		file    *os.File
+		encoder *json.Encoder
+		lock    sync.Mutex
+	}
+)
+
+// readJournal reads the journal at the given path. A last line that was only partly
+// written is ignored.
+func readJournal(path string) (*InstallJournal, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return nil, scanner.Err()
+	}
+	journal := &InstallJournal{dir: filepath.Dir(path)}
+	err = json.Unmarshal(scanner.Bytes(), journal)
+	if err != nil {
+		return nil, err
+	}
+	for scanner.Scan() {
+		entry := journalEntry{}
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			journal.entries = append(journal.entries, entry)
+		}
+	}
+	return journal, nil
+}
+
+// states returns the last state of each file in the journal.
+func (j *InstallJournal) states() map[string]string {
+	states := make(map[string]string, len(j.entries))
+	for _, entry := range j.entries {
+		states[entry.Path] = entry.State
+	}
+	return states
+}
+
+// committing returns whether the journal belongs to a staged installation that was
+// interrupted while being committed, i.e. moved into the target.
+func (j *InstallJournal) committing() bool {
+	return len(j.Staging) > 0 && filepath.Clean(j.dir) != filepath.Clean(j.Staging)
+}
+
+// UnfinishedInstall returns the journal of an unfinished installation of the same
+// product in the target, or in the target's staging directory, or nil if there is
+// none.
+func (i *Installer) UnfinishedInstall() *InstallJournal {
+	for _, dir := range []string{i.Target, i.siblingDir(stagingDirSuffix)} {
+		journal, err := readJournal(filepath.Join(dir, journalFilename))
+		if err != nil || journal.Product != i.config.Variables["product"] {
+			continue
+		}
+		return journal
+	}
+	return nil
+}
+
+// Resumable returns whether there is an unfinished installation in the target which
+// can be resumed, i.e. one of the same version into the same target. A staged
+// installation that was interrupted while being committed can't be resumed.
+func (i *Installer) Resumable() bool {
+	journal := i.UnfinishedInstall()
+	return journal != nil && journal.Version == i.config.Variables["version"] &&
+		filepath.Clean(journal.Target) == i.Target && !journal.committing()
+}
+
+// ResumeUnfinished chooses to resume the unfinished installation in the target, with
+// the choices it was started with, instead of cleaning it up. The files that were
+// already installed are checked again (see checkFile()), and only the remaining ones
+// are installed. Returns an error if the installation can't be resumed.
+func (i *Installer) ResumeUnfinished() error {
+	if !i.Resumable() {
+		return errNotResumable
+	}
+	journal := i.UnfinishedInstall()
+	i.Resume = true
+	i.Update = journal.Update
+	if len(journal.InstallType) > 0 {
+		if err := i.SetInstallType(journal.InstallType); err != nil {
+			return err
+		}
+	}
+	if len(i.config.Components) > 0 {
+		return i.SetComponents(journal.Components)
+	}
+	return nil
+}
+
+// startJournal creates the journal in the installation directory, or continues the
+// journal of a resumed installation.
+func (i *Installer) startJournal(resumed bool) error {
+	path := filepath.Join(i.installDir(), journalFilename)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !resumed {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	i.journal = &journalWriter{file: file, encoder: json.NewEncoder(file)}
+	if resumed {
+		return nil
+	}
+	header := &InstallJournal{
+		Product:     i.config.Variables["product"],
+		Version:     i.config.Variables["version"],
+		Target:      i.Target,
+		Staging:     i.staging,
+		Started:     time.Now(),
+		Update:      i.Update,
+		InstallType: i.installTypeId(),
+		Components:  i.SelectedComponents(),
+	}
+	err = i.journal.encoder.Encode(header)
+	if err == nil {
+		err = file.Sync()
+	}
+	return err
+}
+
+// journalFile appends the state of a file to the journal. The journal is not synced to
+// disk for each file, because files journaled as installed are checked again anyway
+// when resuming.
+func (i *Installer) journalFile(file *InstallFile, state string) {
+	if i.journal == nil {
+		return
+	}
+	i.journal.lock.Lock()
+	defer i.journal.lock.Unlock()
+	i.journal.encoder.Encode(journalEntry{Path: file.Target, State: state})
+}
+
+// closeJournal closes the journal, and removes it if the installation is finished or
+// was rolled back. Otherwise it is kept, so that the installation can be resumed or
+// cleaned up later. A journal that was closed before is still removed, from the
+// directory the files are currently installed into.
+func (i *Installer) closeJournal(remove bool) {
+	if i.journal != nil {
+		i.journal.lock.Lock()
+		i.journal.file.Close()
+		i.journal.lock.Unlock()
+		i.journal = nil
+	}
+	if remove {
+		os.Remove(filepath.Join(i.installDir(), journalFilename))
+	}
+}
+
+// resumeFiles marks the files of the resumed installation that were journaled as
+// installed or unchanged, and still match the payload, as done. Any other file that the
+// installation started to write is removed again, keeping the backup of the file it
+// overwrote, if any, as is the partial file of a file that wasn't journaled yet. In a
+// staging directory all other files are removed, since they may be hard links into the
+// target.
+func (i *Installer) resumeFiles(journal *InstallJournal) {
+	states := journal.states()
+	for _, file := range i.files {
+		state, journaled := states[file.Target]
+		if (state == journalInstalled || state == journalUnchanged) &&
+			i.checkFile(file) == nil {
+			if state == journalUnchanged {
+				file.unchanged = true
+			} else {
+				file.installed = true
+			}
+			i.installedSize += int64(file.UncompressedSize64)
+		} else if file.IsDir() {
+			continue
+		} else if !journaled && len(i.staging) == 0 {
+			// only journaled once it replaces the existing file, see replaceIfChanged()
+			os.Remove(partialPath(i.fileTarget(file)))
+			continue
+		} else {
+			os.Remove(i.fileTarget(file))
+			os.Remove(partialPath(i.fileTarget(file)))
+		}
+		if len(i.staging) == 0 && !file.IsDir() {
+			backupPath := filepath.Join(i.backupDir(), file.Target)
+			if _, err := os.Lstat(backupPath); err == nil {
+				file.backup = backupPath
+			}
+		}
+	}
+	slog.Info("Resuming installation", "path", i.installDir())
+}
+
+// cleanUpUnfinished removes the files of an unfinished installation, restores the files
+// it overwrote or removed from the backup, and removes its journal. A staged
+// installation is cleaned up by removing its staging directory, see cleanUpStaging().
+// Partial files of the payload's files are removed as well, since a file may be
+// partially written before it is journaled.
+func (i *Installer) cleanUpUnfinished(journal *InstallJournal) {
+	if len(journal.Staging) > 0 {
+		err := i.cleanUpStaging(journal)
+		if err != nil {
+			slog.Warn(
+				"Unable to clean up unfinished installation", "path", journal.Staging,
+				ErrorAttr(err),
+			)
+			return
+		}
+		slog.Info("Cleaned up unfinished installation", "path", journal.Staging)
+		return
+	}
+	for _, file := range i.files {
+		if !file.IsDir() {
+			// not journaled yet, see replaceIfChanged()
+			os.Remove(partialPath(filepath.Join(i.Target, file.Target)))
+		}
+	}
+	// reversed -> remove dir content before dir
+	for p := len(journal.entries) - 1; p >= 0; p-- {
+		if journal.entries[p].State != journalUnchanged {
+			path := filepath.Join(i.Target, journal.entries[p].Path)
+			os.Remove(path)
+			os.Remove(partialPath(path))
+		}
+	}
+	filepath.Walk(i.backupDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(i.backupDir(), path)
+		if err != nil {
+			return nil
+		}
+		target := filepath.Join(i.Target, relPath)
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			os.MkdirAll(filepath.Dir(target), 0755)
+			if os.Rename(path, target) == nil {
+				slog.Debug("Restored file", "file", target)
+			}
+		}
+		return nil
+	})
+	os.RemoveAll(i.backupDir())
+	os.Remove(filepath.Join(i.Target, journalFilename))
+	slog.Info("Cleaned up unfinished installation", "path", i.Target)
+}
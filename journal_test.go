@@ -0,0 +1,198 @@
+package linux_installer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestJournal writes a journal of an installation of the test product into dir,
+// with the given entries and an optional partly written last line.
+func writeTestJournal(
+	t *testing.T, dir string, version string, entries []journalEntry, partial string,
+) {
+	t.Helper()
+	file, err := os.Create(filepath.Join(dir, journalFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	header := &InstallJournal{Product: "Test", Version: version, Target: dir}
+	if err := encoder.Encode(header); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := file.WriteString(partial); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestFiles writes the files with the given contents into dir.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkNotExist fails the test if any of the paths exist.
+func checkNotExist(t *testing.T, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("%s exists, want it removed", path)
+		}
+	}
+}
+
+func TestReadJournal(t *testing.T) {
+	tests := []struct {
+		name    string
+		partial string
+		want    map[string]string
+	}{
+		{"complete", "", map[string]string{"app/a": journalInstalled}},
+		{"partial last line", `{"path":"app/b","sta`, map[string]string{
+			"app/a": journalInstalled,
+		}},
+		{"partial last line of a file", `{"path":"app/a"`, map[string]string{
+			"app/a": journalInstalled,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestJournal(t, dir, "1.0", []journalEntry{
+				{"app/a", journalStarted}, {"app/a", journalInstalled},
+			}, test.partial)
+			journal, err := readJournal(filepath.Join(dir, journalFilename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if journal.Product != "Test" || journal.Version != "1.0" {
+				t.Errorf(
+					"journal of %s %s, want Test 1.0", journal.Product, journal.Version,
+				)
+			}
+			states := journal.states()
+			if len(states) != len(test.want) {
+				t.Errorf("states = %v, want %v", states, test.want)
+			}
+			for path, state := range test.want {
+				if states[path] != state {
+					t.Errorf("state of %s = %q, want %q", path, states[path], state)
+				}
+			}
+		})
+	}
+}
+
+func TestResumeInstall(t *testing.T) {
+	target := t.TempDir()
+	writeTestFiles(t, target, map[string]string{
+		"app/a":              "alpha", // installed
+		"app/b":              "be",    // interrupted
+		"app/.c.installing":  "gam",   // interrupted before it was journaled
+		"app/.b.installing":  "bet",
+		"app/user-file.conf": "keep",
+	})
+	writeTestJournal(t, target, "1.0", []journalEntry{
+		{"app/", journalInstalled},
+		{"app/a", journalStarted},
+		{"app/a", journalInstalled},
+		{"app/l", journalStarted},
+		{"app/b", journalStarted},
+	}, "")
+	before, err := os.Stat(filepath.Join(target, "app", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := newTestTarPayload(t, "tar.zst", testEntries)
+	installer := newPayloadInstaller(t, target, payload)
+	if err := installer.ResumeUnfinished(); err != nil {
+		t.Fatal(err)
+	}
+	if err := installer.install(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkInstalledFiles(t, target, map[string]string{
+		"app/a": "alpha", "app/h": "alpha", "app/b": "beta", "app/c": "gamma",
+		"app/user-file.conf": "keep",
+	})
+	if after, err := os.Stat(filepath.Join(target, "app", "a")); err != nil ||
+		!os.SameFile(before, after) {
+		t.Error("app/a was written again, want it kept")
+	}
+	if link, err := os.Readlink(filepath.Join(target, "app", "l")); link != "a" {
+		t.Errorf("symlink = %q, %v, want %q", link, err, "a")
+	}
+	checkNotExist(
+		t,
+		filepath.Join(target, journalFilename),
+		filepath.Join(target, "app", ".b.installing"),
+		filepath.Join(target, "app", ".c.installing"),
+	)
+}
+
+func TestResumeOtherVersion(t *testing.T) {
+	target := t.TempDir()
+	writeTestJournal(t, target, "0.9", nil, "")
+	payload, _ := newTestTarPayload(t, "tar.zst", testEntries)
+	installer := newPayloadInstaller(t, target, payload)
+	if installer.UnfinishedInstall() == nil {
+		t.Error("unfinished installation not found")
+	}
+	if err := installer.ResumeUnfinished(); err != errNotResumable {
+		t.Errorf("ResumeUnfinished() = %v, want errNotResumable", err)
+	}
+}
+
+func TestCleanUpUnfinished(t *testing.T) {
+	target := t.TempDir()
+	writeTestFiles(t, target, map[string]string{
+		"app/a":                         "alpha",
+		"app/b":                         "be",
+		"app/.b.installing":             "bet",
+		"app/.c.installing":             "gam",
+		"app/user-file.conf":            "keep",
+		backupDirName + "/app/b":        "old b",
+		backupDirName + "/app/obsolete": "old",
+	})
+	writeTestJournal(t, target, "0.9", []journalEntry{
+		{"app/", journalInstalled},
+		{"app/a", journalStarted},
+		{"app/a", journalInstalled},
+		{"app/b", journalStarted},
+	}, "")
+	payload, _ := newTestTarPayload(t, "tar.zst", testEntries)
+	installer := newPayloadInstaller(t, target, payload)
+	journal := installer.UnfinishedInstall()
+	if journal == nil {
+		t.Fatal("unfinished installation not found")
+	}
+	installer.cleanUpUnfinished(journal)
+	checkInstalledFiles(t, target, map[string]string{
+		"app/b": "old b", "app/obsolete": "old", "app/user-file.conf": "keep",
+	})
+	checkNotExist(
+		t,
+		filepath.Join(target, "app", "a"),
+		filepath.Join(target, "app", ".b.installing"),
+		filepath.Join(target, "app", ".c.installing"),
+		filepath.Join(target, journalFilename),
+		installer.backupDir(),
+	)
+}
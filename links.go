@@ -0,0 +1,230 @@
+package linux_installer
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// specialModeBits are the special permission bits which are taken over from the payload.
+// The setuid bit is left out on purpose, so that the payload can't install programs
+// which run as the installing user, which might be root.
+const specialModeBits = os.ModeSetgid | os.ModeSticky
+
+// maxSymlinkSize is the maximum length of a symlink target in the payload.
+const maxSymlinkSize = 4096
+
+// maxSymlinkDepth is the maximum number of nested symlinks that are followed when
+// checking a symlink, like the OS's own limit.
+const maxSymlinkDepth = 40
+
+// symlink returns whether the file is a symlink in the payload. Zip archives only
+// contain symlinks if they were created with "zip --symlinks".
+func (file *InstallFile) symlink() bool {
+	return file.Mode()&os.ModeSymlink != 0
+}
+
+// createDir creates a directory with the permissions from the payload, as restricted by
+// the umask. The owning user always has full access, so that the directory's files can
+// be installed. Existing directories are kept as they are.
+func (i *Installer) createDir(file *InstallFile) error {
+	path := i.fileTarget(file)
+	err := i.createParentDir(file)
+	if err != nil {
+		return err
+	}
+	err = os.Mkdir(path, file.Mode().Perm()|0700)
+	if os.IsExist(err) {
+		if info, statErr := os.Stat(path); statErr == nil && !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return setSpecialBits(path, file.Mode())
+}
+
+// installSymlink creates a symlink from the payload. Links which point outside of the
+// installation directory, directly or through other links, are refused with
+// ErrPayloadCorrupt.
+func (i *Installer) installSymlink(file *InstallFile) error {
+	linkTarget, err := file.symlinkTarget()
+	if err != nil {
+		return err
+	}
+	path := i.fileTarget(file)
+	if !i.symlinkInside(path, linkTarget) {
+		return errSymlinkOutside(file)
+	}
+	err = i.createParentDir(file)
+	if err != nil {
+		return err
+	}
+	err = os.Symlink(linkTarget, path)
+	if err != nil {
+		return err
+	}
+	file.link = linkTarget
+	return nil
+}
+
+// checkSymlinks checks all installed symlinks again once all files are installed,
+// because a link can point outside of the installation through another link that was
+// only created after it.
+func (i *Installer) checkSymlinks() error {
+	for _, file := range i.files {
+		if !file.installed || !file.symlink() {
+			continue
+		}
+		if !i.symlinkInside(i.fileTarget(file), file.link) {
+			return errSymlinkOutside(file)
+		}
+	}
+	return nil
+}
+
+// installHardLink creates a hard link to the file it links to in the payload. If the
+// link can't be created, that file is copied on disk instead. Only if it isn't
+// installed, e.g. because it belongs to a component that wasn't selected, the content
+// is read from the payload again.
+func (i *Installer) installHardLink(file *InstallFile) error {
+	if !file.hardLink.installed && !file.hardLink.unchanged {
+		return i.installFile(file)
+	}
+	path := i.fileTarget(file)
+	err := i.createParentDir(file)
+	if err != nil {
+		return err
+	}
+	err = os.Link(i.fileTarget(file.hardLink), path)
+	if err == nil {
+		return nil
+	}
+	slog.Debug(
+		"Unable to create hard link, copying the file", "file", path, ErrorAttr(err),
+	)
+	source, err := os.Open(i.fileTarget(file.hardLink))
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	return i.writeFile(file, source)
+}
+
+// errSymlinkOutside returns the error for a symlink in the payload which points outside
+// of the installation.
+func errSymlinkOutside(file *InstallFile) error {
+	return ErrPayloadCorrupt.wrap(
+		fmt.Errorf("symlink %s points outside of the installation", file.Target),
+	)
+}
+
+// errParentOutside returns the error for a file in the payload whose directory resolves
+// to a path outside of the installation, through symlinks installed before it.
+func errParentOutside(file *InstallFile) error {
+	return ErrPayloadCorrupt.wrap(
+		fmt.Errorf("%s is outside of the installation", file.Target),
+	)
+}
+
+// createParentDir creates the directory of a file's target path, after checking that
+// it resolves to a path inside the installation directory (see parentInside()).
+// Otherwise nothing is created and the error is ErrPayloadCorrupt.
+func (i *Installer) createParentDir(file *InstallFile) error {
+	path := i.fileTarget(file)
+	if _, _, ok := i.parentInside(path); !ok {
+		return errParentOutside(file)
+	}
+	return os.MkdirAll(filepath.Dir(path), 0755)
+}
+
+// parentInside resolves the directory of a path in the installation directory, and
+// returns whether it stays inside, see resolveInside(). A file written into a directory
+// that resolves outside would escape the installation through symlinks from the
+// payload, even if each of them is checked on its own. root is the installation
+// directory, and dir the resolved directory.
+func (i *Installer) parentInside(path string) (root string, dir string, ok bool) {
+	root, err := filepath.EvalSymlinks(i.installDir())
+	if err != nil {
+		return "", "", false
+	}
+	relDir, err := filepath.Rel(i.installDir(), filepath.Dir(path))
+	if err != nil {
+		return "", "", false
+	}
+	dir, ok = resolveInside(root, root, relDir, 0)
+	return root, dir, ok
+}
+
+// symlinkInside returns whether a symlink at path with the given link target resolves
+// to a path inside the installation directory. Absolute link targets are never inside.
+func (i *Installer) symlinkInside(path string, linkTarget string) bool {
+	root, dir, ok := i.parentInside(path)
+	if !ok {
+		return false
+	}
+	_, ok = resolveInside(root, dir, linkTarget, 0)
+	return ok
+}
+
+// resolveInside resolves the relative path rel from the directory dir, component by
+// component and following symlinks like the OS would. It returns false if the path
+// leaves root at any point, or has too many nested symlinks. Components that don't exist
+// yet are taken as they are. root and dir must not contain symlinks themselves.
+func resolveInside(root string, dir string, rel string, depth int) (string, bool) {
+	if depth > maxSymlinkDepth || filepath.IsAbs(rel) {
+		return "", false
+	}
+	resolved := dir
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == root {
+				return "", false
+			}
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		if linkTarget, err := os.Readlink(next); err == nil {
+			var ok bool
+			next, ok = resolveInside(root, resolved, linkTarget, depth+1)
+			if !ok {
+				return "", false
+			}
+		}
+		resolved = next
+	}
+	return resolved, true
+}
+
+// setSpecialBits adds the setgid and sticky bits of mode to an installed file or
+// directory, keeping its other permissions. The OS may silently drop the setgid bit,
+// e.g. if the user isn't a member of the file's group, which is only logged.
+func setSpecialBits(path string, mode os.FileMode) error {
+	if mode&specialModeBits == 0 {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(path, info.Mode().Perm()|mode&specialModeBits)
+	if err != nil {
+		return err
+	}
+	if info, err = os.Lstat(path); err == nil &&
+		info.Mode()&specialModeBits != mode&specialModeBits {
+		slog.Warn(
+			"Unable to set all special permissions", "file", path,
+			"mode", mode&specialModeBits,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package linux_installer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParentInside(t *testing.T) {
+	target := t.TempDir()
+	installer := &Installer{Target: target}
+	links := []struct{ path, link string }{
+		{"a", "b/.."}, // inside on its own, while b doesn't exist yet
+		{"b", "."},
+		{"up", ".."},
+		{"lib/current", "../lib"},
+	}
+	if err := os.Mkdir(filepath.Join(target, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range links {
+		err := os.Symlink(link.link, filepath.Join(target, link.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"x", true},
+		{"new/dir/x", true},
+		{"b/x", true},
+		{"lib/current/x", true},
+		{"lib/current/current/x", true},
+		{"a/x", false},
+		{"up/x", false},
+		{"b/up/x", false},
+	}
+	for _, test := range tests {
+		path := filepath.Join(target, filepath.FromSlash(test.path))
+		if _, _, got := installer.parentInside(path); got != test.want {
+			t.Errorf("parentInside(%s) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
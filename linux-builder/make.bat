@@ -15,7 +15,8 @@ set "%3=%4" > nul
 
 set DATA_SRC_DIR=data
 set RESOURCE_SRC_DIR=resources
-set DATA_DIST_DIR=data_compressed
+set DATA_DIST_DIR=data-compressed
+set SIGN_KEY=signing.key
 
 
 call :replace_version
@@ -52,5 +53,7 @@ goto :eof
 :rice_append
     echo Appending data to installer...
     copy /y "%INPUT%" "%OUTPUT%" > nul
+    if not exist "%SIGN_KEY%" payload-sign -genkey "%SIGN_KEY%" > nul
+    payload-sign -key "%SIGN_KEY%" -installer "%OUTPUT%"
     rice append --exec="%OUTPUT%"
 goto :eof
@@ -0,0 +1,283 @@
+package linux_installer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultLogFilename is the name of the log file, unless "log_filename" is set in
+	// the config.
+	defaultLogFilename = "installer.log"
+	// installedLogFilename is the name of the copy of the log inside the installation
+	// directory, which is made after a successful installation.
+	installedLogFilename = "installer.log"
+	// defaultLogDirName is the name of the log file's directory if the product is
+	// unknown, e.g. because the config can't be read.
+	defaultLogDirName = "linux_installer"
+	// logFormatText and logFormatJson are the formats of the log file, for "log_format"
+	// in the config and the -log-format flag. Text records are key=value pairs, JSON
+	// records one object per line.
+	logFormatText = "text"
+	logFormatJson = "json"
+)
+
+// logFile is the installer's log file, with the slog.Handler for the whole installer.
+// Until the log file is opened, when its location and format are known from the config
+// and the commandline, the log records are kept in memory. If forward is set before the
+// log file is opened, all records are also written to it as JSON. start is the size
+// of the log file when it was opened, where the records of this run begin.
+type logFile struct {
+	lock     sync.Mutex
+	pending  []pendingRecord
+	file     *os.File
+	start    int64
+	forward  io.Writer
+	handlers []slog.Handler
+}
+
+// pendingRecord is a log record from before the log file was opened, with the handler
+// it was logged to.
+type pendingRecord struct {
+	handler *logHandler
+	record  slog.Record
+}
+
+// logHandler is the slog.Handler of the installer. It passes each record on to the log
+// file and, with -verbose or -debug, to the terminal. Attributes and groups added with
+// WithAttrs() and WithGroup() are kept as functions, since the handlers they apply to
+// don't exist before the log file is opened.
+type logHandler struct {
+	log  *logFile
+	with []func(slog.Handler) slog.Handler
+}
+
+// startLogging sets up the logging, and makes the installer's handler the default one
+// for slog and the log package. The records are written once the log file is opened
+// with open().
+func startLogging() *logFile {
+	logfile := &logFile{}
+	slog.SetDefault(slog.New(&logHandler{log: logfile}))
+	return logfile
+}
+
+// ErrorAttr returns a log attribute for an error, with its message, the message of the
+// underlying error if any, and the exit status of the installer process for it.
+func ErrorAttr(err error) slog.Attr {
+	attrs := []any{slog.String("msg", err.Error())}
+	if cause := errors.Unwrap(err); cause != nil {
+		attrs = append(attrs, slog.String("cause", cause.Error()))
+	}
+	attrs = append(attrs, slog.Int("code", ExitCode(err)))
+	return slog.Group("error", attrs...)
+}
+
+// logPath returns the path of the log file. It is flagPath from the commandline if
+// given, or "log_filename" from the config. A relative log_filename is taken relative
+// to the user's state directory for the product, e.g. ~/.local/state/<product>/.
+func logPath(flagPath string, config *Config) string {
+	if len(flagPath) > 0 {
+		return flagPath
+	}
+	filename, product := defaultLogFilename, ""
+	if config != nil {
+		if len(config.LogFilename) > 0 {
+			filename = config.LogFilename
+		}
+		product = config.Variables["product"]
+	}
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+	dirName := strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(product)
+	if len(dirName) == 0 {
+		dirName = defaultLogDirName
+	}
+	stateDir, err := osStateDir() // os-specific
+	if err != nil {
+		return filepath.Join(os.TempDir(), dirName, filename)
+	}
+	return filepath.Join(stateDir, dirName, filename)
+}
+
+// open opens the log file at path for appending, creating its directory if necessary,
+// and writes the records logged so far into it. If path is empty or the file can't be
+// opened, a new log file in the system's temporary directory is used instead, or stderr
+// as a last resort.
+// The records are written in the given format, logFormatText or logFormatJson. If
+// mirror is not nil, records of that level and above are also written to stderr.
+// Returns the path of the log file that is used.
+func (l *logFile) open(path string, format string, mirror slog.Leveler) string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.handlers != nil {
+		return l.name()
+	}
+	var err error
+	filename := defaultLogFilename
+	if len(path) > 0 {
+		filename = filepath.Base(path)
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err == nil {
+			l.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		}
+	}
+	if err != nil {
+		l.pending = append(l.pending, pendingRecord{
+			handler: &logHandler{log: l},
+			record: newLogRecord(
+				slog.LevelWarn, "Unable to open log file", "path", path, ErrorAttr(err),
+			),
+		})
+	}
+	if l.file == nil {
+		pattern := strings.TrimSuffix(filename, ".log") + "-*.log"
+		l.file, err = os.CreateTemp("", pattern)
+	}
+	if err == nil {
+		l.start, _ = l.file.Seek(0, io.SeekEnd)
+	}
+	var out io.Writer = os.Stderr
+	if err == nil {
+		out = l.file
+	}
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if format == logFormatJson {
+		l.handlers = []slog.Handler{slog.NewJSONHandler(out, options)}
+	} else {
+		l.handlers = []slog.Handler{slog.NewTextHandler(out, options)}
+	}
+	if mirror != nil && out != os.Stderr {
+		mirrorOptions := &slog.HandlerOptions{Level: mirror}
+		l.handlers = append(l.handlers, slog.NewTextHandler(os.Stderr, mirrorOptions))
+	}
+	if l.forward != nil {
+		l.handlers = append(l.handlers, slog.NewJSONHandler(l.forward, options))
+	}
+	for _, pending := range l.pending {
+		pending.handler.handle(context.Background(), pending.record)
+	}
+	l.pending = nil
+	return l.name()
+}
+
+// name returns the path of the log file, or an empty string if there is none.
+func (l *logFile) name() string {
+	if l.file == nil {
+		return ""
+	}
+	return l.file.Name()
+}
+
+// close closes the log file. If it was never opened, the records logged so far are
+// written to the default log file first.
+func (l *logFile) close() {
+	l.open(logPath("", nil), logFormatText, nil)
+	if l.file != nil {
+		l.file.Close()
+	}
+}
+
+// newLogRecord returns a log record with the current time, as slog.Logger creates it.
+func newLogRecord(level slog.Level, msg string, args ...any) slog.Record {
+	record := slog.NewRecord(time.Now(), level, msg, 0)
+	record.Add(args...)
+	return record
+}
+
+// Enabled implements slog.Handler. All levels are enabled, since the log file gets all
+// records.
+func (h *logHandler) Enabled(ctx context.Context, level slog.Level) bool { return true }
+
+// Handle implements slog.Handler. It keeps the record if the log file isn't opened yet.
+func (h *logHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.log.lock.Lock()
+	defer h.log.lock.Unlock()
+	if h.log.handlers == nil {
+		h.log.pending = append(h.log.pending, pendingRecord{h, record.Clone()})
+		return nil
+	}
+	return h.handle(ctx, record)
+}
+
+// handle passes a record on to the log file's handlers which are enabled for its
+// level. The log's lock must be held.
+func (h *logHandler) handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, handler := range h.log.handlers {
+		for _, with := range h.with {
+			handler = with(handler)
+		}
+		if handler.Enabled(ctx, record.Level) {
+			errs = append(errs, handler.Handle(ctx, record))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.withFunc(func(handler slog.Handler) slog.Handler {
+		return handler.WithAttrs(attrs)
+	})
+}
+
+// WithGroup implements slog.Handler.
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	return h.withFunc(func(handler slog.Handler) slog.Handler {
+		return handler.WithGroup(name)
+	})
+}
+
+// withFunc returns a copy of the handler, which applies with to the log file's handlers
+// after the functions it already has.
+func (h *logHandler) withFunc(with func(slog.Handler) slog.Handler) *logHandler {
+	return &logHandler{log: h.log, with: append(h.with[:len(h.with):len(h.with)], with)}
+}
+
+// copyLog copies the log of this run into the installation directory, so that it can
+// be found there when needed for support. The log file may hold earlier runs as well,
+// which are left out, see Config.LogStart.
+func (i *Installer) copyLog() {
+	if len(i.config.LogPath) == 0 {
+		return
+	}
+	target := filepath.Join(i.Target, installedLogFilename)
+	slog.Info("Copying log", "path", target)
+	err := copyFileFrom(i.config.LogPath, i.config.LogStart, target)
+	if err != nil {
+		slog.Warn("Unable to copy log", "path", target, ErrorAttr(err))
+	}
+}
+
+// copyFileFrom copies the content of a file from the given offset on to a new file at
+// target. An existing file at target is replaced, since it may be a hard link into the
+// previous installation.
+func copyFileFrom(path string, offset int64, target string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	_, err = source.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	os.Remove(target)
+	copied, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(copied, source)
+	if closeErr := copied.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+// Command payload-sign signs the payload of an installer before it is appended with
+// "rice append". It is run in the builder directory, which contains the "resources" and
+// "data-compressed" directories.
+//
+// Usage:
+//
+//	payload-sign -genkey signing.key > signing.pub
+//	            // Create a new key pair, write the private key into signing.key and
+//	            // print the public key, which is compiled into the installer.
+//	payload-sign -key signing.key -pubkey > signing.pub
+//	            // Print the public key of an existing private key.
+//	payload-sign -key signing.key -installer Setup
+//	            // Sign the payload in the current directory with the private key, and
+//	            // write the public key into Setup, a fresh copy of the naked installer.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	// this is the installer package name - here it refers to the parent directory
+	"github.com/grandchild/linux_installer"
+)
+
+func main() {
+	genKey := flag.String("genkey", "", "Create a new key pair, write the private key into this file and print the public key")
+	keyFile := flag.String("key", "signing.key", "Private key to sign the payload with")
+	dir := flag.String("dir", ".", "Directory containing the payload directories")
+	printPublicKey := flag.Bool("pubkey", false, "Print the public key of the private key and exit")
+	installer := flag.String("installer", "", "Write the public key into this copy of the naked installer")
+	flag.Parse()
+
+	if len(*genKey) > 0 {
+		publicKey, err := linux_installer.GenerateSigningKey(*genKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(publicKey)
+		return
+	}
+	privateKey, err := linux_installer.LoadSigningKey(*keyFile)
+	if err == nil && *printPublicKey {
+		fmt.Println(linux_installer.SigningPublicKey(privateKey))
+		return
+	}
+	if err == nil {
+		err = linux_installer.SignPayload(*dir, privateKey)
+	}
+	if err == nil && len(*installer) > 0 {
+		err = linux_installer.EmbedPublicKey(
+			*installer, linux_installer.SigningPublicKey(privateKey),
+		)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,445 @@
+package linux_installer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type (
+	// PayloadFile is a file, directory or link inside one of the payload archives in the
+	// data box. Its fields are named like those of zip.FileHeader, and are known before
+	// anything is installed, so that the installation's progress can be calculated.
+	//
+	// CRC32 is the checksum of the file's content. Tar archives don't store checksums,
+	// so for their files it is only known once the content was read, see checksum().
+	// Hard links have the size and checksum of the file they link to.
+	PayloadFile struct {
+		Name               string
+		Modified           time.Time
+		CRC32              uint32
+		UncompressedSize64 uint64
+		checksummed        bool
+		mode               os.FileMode
+		linkTarget         string
+		hardLink           *PayloadFile
+		reader             payloadReader
+		index              int
+		zipFile            *zip.File
+	}
+	// payloadReader reads the files of a payload archive. There are readers for each of
+	// the formats in payloadFormats.
+	payloadReader interface {
+		// Files returns all files, directories and links in the archive, in archive
+		// order.
+		Files() []*PayloadFile
+		// Open returns a reader for the content of a file in the archive. The reader
+		// fails with ErrPayloadCorrupt if the content doesn't match the file's checksum.
+		Open(file *PayloadFile) (io.ReadCloser, error)
+		// Sequential returns whether the archive can only be read efficiently in archive
+		// order. The files of such archives are installed one after the other.
+		Sequential() bool
+	}
+	// payloadFormat is an archive format, recognized by its file extension, and the
+	// function to open an archive in the data box in that format.
+	payloadFormat struct {
+		extension string
+		open      func(name string) (payloadReader, error)
+	}
+)
+
+// tarReadBufferSize is the size of the buffer for reading compressed tar archives from
+// the data box.
+const tarReadBufferSize = 1024 * 1024
+
+// payloadFormats are the supported formats for archives in the data box.
+var payloadFormats = []payloadFormat{
+	{".zip", openZipPayload},
+	{".tar.zst", func(name string) (payloadReader, error) {
+		return openTarPayload(name, decompressZstd)
+	}},
+	{".tar.xz", func(name string) (payloadReader, error) {
+		return openTarPayload(name, decompressXz)
+	}},
+}
+
+// openPayload opens an archive in the data box with the reader for its format. If the
+// format is not supported, ok is false.
+func openPayload(name string) (reader payloadReader, ok bool, err error) {
+	for _, format := range payloadFormats {
+		if strings.HasSuffix(strings.ToLower(name), format.extension) {
+			reader, err = format.open(name)
+			return reader, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// Mode returns the file's type and permissions.
+func (f *PayloadFile) Mode() os.FileMode { return f.mode }
+
+// IsDir returns whether the file is a directory.
+func (f *PayloadFile) IsDir() bool { return f.mode.IsDir() }
+
+// Open returns a reader for the file's content. For hard links this is the content of
+// the file they link to.
+func (f *PayloadFile) Open() (io.ReadCloser, error) {
+	if f.hardLink != nil {
+		return f.hardLink.Open()
+	}
+	return f.reader.Open(f)
+}
+
+// checksum returns the CRC32 checksum of the file's content. If it isn't known yet, the
+// content is read to calculate it.
+func (f *PayloadFile) checksum() (uint32, error) {
+	if f.hardLink != nil {
+		return f.hardLink.checksum()
+	}
+	if !f.checksummed {
+		reader, err := f.Open()
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.Copy(io.Discard, reader)
+		reader.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return f.CRC32, nil
+}
+
+// sequential returns whether the file's archive can only be read in archive order.
+func (f *PayloadFile) sequential() bool { return f.reader.Sequential() }
+
+// symlinkTarget returns the target of a symlink. Tar archives have it in the header,
+// while zip archives store it as the content of the file.
+func (f *PayloadFile) symlinkTarget() (string, error) {
+	if f.zipFile == nil {
+		return f.linkTarget, nil
+	}
+	reader, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(io.LimitReader(reader, maxSymlinkSize))
+	return string(content), err
+}
+
+// payloadName normalizes the name of a file in an archive like zip names them, i.e.
+// relative, with slashes, and with a trailing slash for directories.
+func payloadName(name string, dir bool) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if dir && len(name) > 0 {
+		name += "/"
+	}
+	return name
+}
+
+// zipPayload reads a zip archive, directly from the data box. Zip files can be read in
+// any order.
+type zipPayload struct {
+	files []*PayloadFile
+}
+
+// openZipPayload opens a zip archive in the data box.
+func openZipPayload(name string) (payloadReader, error) {
+	zipReader, err := openDataZip(name)
+	if err != nil {
+		return nil, err
+	}
+	payload := &zipPayload{files: make([]*PayloadFile, 0, len(zipReader.File))}
+	for index, file := range zipReader.File {
+		payload.files = append(payload.files, &PayloadFile{
+			Name:               file.Name,
+			Modified:           file.Modified,
+			CRC32:              file.CRC32,
+			UncompressedSize64: file.UncompressedSize64,
+			checksummed:        true,
+			mode:               file.Mode(),
+			reader:             payload,
+			index:              index,
+			zipFile:            file,
+		})
+	}
+	return payload, nil
+}
+
+// Files implements payloadReader.
+func (p *zipPayload) Files() []*PayloadFile { return p.files }
+
+// Sequential implements payloadReader.
+func (p *zipPayload) Sequential() bool { return false }
+
+// Open implements payloadReader.
+func (p *zipPayload) Open(file *PayloadFile) (io.ReadCloser, error) {
+	reader, err := file.zipFile.Open()
+	if err != nil {
+		return nil, ErrPayloadCorrupt.wrap(err)
+	}
+	return &zipFileReader{reader}, nil
+}
+
+// zipFileReader reads a file from a zip archive, and wraps zip errors in
+// ErrPayloadCorrupt.
+type zipFileReader struct {
+	io.ReadCloser
+}
+
+// Read implements io.Reader.
+func (r *zipFileReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrFormat) ||
+		errors.Is(err, zip.ErrAlgorithm) {
+		err = ErrPayloadCorrupt.wrap(err)
+	}
+	return n, err
+}
+
+// tarPayload reads a compressed tar archive from the data box. The archive is scanned
+// once when it is opened, to list its files with their sizes from the tar headers.
+// Reading a file then continues from the current position in the archive, or starts
+// over from the beginning if the file was already passed. Files are read in archive
+// order during the installation, so that only happens for hard links to files which
+// aren't installed, see installHardLink().
+//
+// Tar archives have no checksums of their files, only the compression formats have
+// checksums of the whole archive. The checksum of each file is calculated while it is
+// read, and checked if the file is read again.
+type tarPayload struct {
+	open       func() (io.ReadCloser, error)
+	decompress func(io.Reader) (io.ReadCloser, error)
+	files      []*PayloadFile
+	lock       sync.Mutex
+	stream     *tarStream
+}
+
+// tarStream is a pass through a compressed tar archive. next is the index of the next
+// entry in the archive.
+type tarStream struct {
+	file         io.ReadCloser
+	decompressor io.ReadCloser
+	reader       *tar.Reader
+	next         int
+}
+
+// openTarPayload opens a tar archive in the data box, which is decompressed with the
+// given function.
+func openTarPayload(
+	name string, decompress func(io.Reader) (io.ReadCloser, error),
+) (payloadReader, error) {
+	open := func() (io.ReadCloser, error) { return openDataFile(name) }
+	return newTarPayload(open, decompress)
+}
+
+// newTarPayload opens a tar archive, which open reads from the start on each pass, see
+// openStream().
+func newTarPayload(
+	open func() (io.ReadCloser, error),
+	decompress func(io.Reader) (io.ReadCloser, error),
+) (*tarPayload, error) {
+	payload := &tarPayload{open: open, decompress: decompress}
+	return payload, payload.scan()
+}
+
+// decompressZstd decompresses a zstd stream.
+func decompressZstd(reader io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
+
+// decompressXz decompresses an xz stream.
+func decompressXz(reader io.Reader) (io.ReadCloser, error) {
+	decoder, err := xz.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(decoder), nil
+}
+
+// scan lists all files in the archive from their headers, without reading their
+// content. Entries other than files, directories, symlinks and hard links are skipped.
+func (p *tarPayload) scan() error {
+	stream, err := p.openStream()
+	if err != nil {
+		return err
+	}
+	defer stream.close()
+	byName := make(map[string]*PayloadFile)
+	for {
+		header, err := stream.reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		index := stream.next
+		stream.next++
+		file := &PayloadFile{
+			Name:     payloadName(header.Name, header.Typeflag == tar.TypeDir),
+			Modified: header.ModTime,
+			mode:     header.FileInfo().Mode(),
+			reader:   p,
+			index:    index,
+		}
+		if len(file.Name) == 0 {
+			continue // the archive's root directory
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			file.UncompressedSize64 = uint64(header.Size)
+		case tar.TypeDir:
+			file.checksummed = true
+		case tar.TypeSymlink:
+			file.linkTarget = header.Linkname
+			file.UncompressedSize64 = uint64(len(header.Linkname))
+			file.checksummed = true
+		case tar.TypeLink:
+			target, ok := byName[payloadName(header.Linkname, false)]
+			if !ok || target.IsDir() || target.hardLink != nil {
+				return fmt.Errorf("%s links to unknown file %s", header.Name, header.Linkname)
+			}
+			file.mode = target.mode
+			file.hardLink = target
+			file.UncompressedSize64 = target.UncompressedSize64
+		default:
+			continue
+		}
+		byName[file.Name] = file
+		p.files = append(p.files, file)
+	}
+	return nil
+}
+
+// Files implements payloadReader.
+func (p *tarPayload) Files() []*PayloadFile { return p.files }
+
+// Sequential implements payloadReader.
+func (p *tarPayload) Sequential() bool { return true }
+
+// Open implements payloadReader. The archive is locked until the returned reader is
+// closed.
+func (p *tarPayload) Open(file *PayloadFile) (io.ReadCloser, error) {
+	p.lock.Lock()
+	err := p.seek(file.index)
+	if err != nil {
+		p.lock.Unlock()
+		return nil, ErrPayloadCorrupt.wrap(err)
+	}
+	return &tarFileReader{
+		reader:  p.stream.reader,
+		hash:    crc32.NewIEEE(),
+		file:    file,
+		release: p.lock.Unlock,
+	}, nil
+}
+
+// seek moves the stream to the entry with the given index, so that the stream's reader
+// reads its content. If the entry was already passed, the archive is read again from
+// the start.
+func (p *tarPayload) seek(index int) error {
+	if p.stream != nil && p.stream.next > index {
+		p.stream.close()
+		p.stream = nil
+	}
+	if p.stream == nil {
+		stream, err := p.openStream()
+		if err != nil {
+			return err
+		}
+		p.stream = stream
+	}
+	for p.stream.next <= index {
+		_, err := p.stream.reader.Next()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+		p.stream.next++
+	}
+	return nil
+}
+
+// openStream starts a new pass through the archive.
+func (p *tarPayload) openStream() (*tarStream, error) {
+	file, err := p.open()
+	if err != nil {
+		return nil, err
+	}
+	decompressor, err := p.decompress(bufio.NewReaderSize(file, tarReadBufferSize))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &tarStream{
+		file:         file,
+		decompressor: decompressor,
+		reader:       tar.NewReader(decompressor),
+	}, nil
+}
+
+// close ends a pass through the archive.
+func (s *tarStream) close() {
+	s.decompressor.Close()
+	s.file.Close()
+}
+
+// tarFileReader reads a file from a tar archive. When the end of the file is reached,
+// its size is checked, and its checksum set on the file, or checked if it was read
+// before.
+type tarFileReader struct {
+	reader  io.Reader
+	hash    hash.Hash32
+	size    uint64
+	file    *PayloadFile
+	release func()
+}
+
+// Read implements io.Reader.
+func (r *tarFileReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hash.Write(p[:n])
+	r.size += uint64(n)
+	if err == io.EOF {
+		sum := r.hash.Sum32()
+		if r.size != r.file.UncompressedSize64 ||
+			r.file.checksummed && sum != r.file.CRC32 {
+			return n, ErrPayloadCorrupt.wrap(
+				fmt.Errorf("%s doesn't match its checksum", r.file.Name),
+			)
+		}
+		r.file.CRC32 = sum
+		r.file.checksummed = true
+	} else if err != nil {
+		err = ErrPayloadCorrupt.wrap(err)
+	}
+	return n, err
+}
+
+// Close implements io.Closer, and releases the archive for the next file.
+func (r *tarFileReader) Close() error {
+	if r.release != nil {
+		r.release()
+		r.release = nil
+	}
+	return nil
+}
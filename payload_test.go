@@ -0,0 +1,355 @@
+package linux_installer
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// testEntry is an entry of a test archive. Names ending in "/" are directories. link is
+// the target of a symlink, or the name of the file a hard link links to.
+type testEntry struct {
+	name     string
+	content  string
+	link     string
+	hardLink bool
+}
+
+// testEntries are the entries of the test archives: a directory with two files, a
+// symlink and a hard link, and a fifo, which is skipped.
+var testEntries = []testEntry{
+	{name: "app/"},
+	{name: "app/a", content: "alpha"},
+	{name: "app/l", link: "a"},
+	{name: "app/h", link: "app/a", hardLink: true},
+	{name: "app/b", content: "beta"},
+	{name: "app/c", content: "gamma"},
+	{name: "app/fifo", link: "-"},
+}
+
+// testCompressors compress the test archives in each of the tar payload formats.
+var testCompressors = map[string]struct {
+	compress   func(io.Writer) (io.WriteCloser, error)
+	decompress func(io.Reader) (io.ReadCloser, error)
+}{
+	"tar.zst": {
+		func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+		decompressZstd,
+	},
+	"tar.xz": {
+		func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) },
+		decompressXz,
+	},
+}
+
+// writeTestTar returns a tar archive of the entries, compressed in the given format.
+func writeTestTar(t *testing.T, format string, entries []testEntry) []byte {
+	t.Helper()
+	archive := &bytes.Buffer{}
+	compressor, err := testCompressors[format].compress(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := tar.NewWriter(compressor)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg}
+		switch {
+		case entry.name[len(entry.name)-1] == '/':
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+		case entry.link == "-":
+			header.Typeflag = tar.TypeFifo
+		case entry.hardLink:
+			header.Typeflag, header.Linkname = tar.TypeLink, entry.link
+		case len(entry.link) > 0:
+			header.Typeflag, header.Linkname = tar.TypeSymlink, entry.link
+		default:
+			header.Size = int64(len(entry.content))
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archive.Bytes()
+}
+
+// newTestTarPayload returns a tar payload of the entries in the given format, and the
+// number of passes through the archive so far, not counting the scan when it is opened.
+func newTestTarPayload(
+	t *testing.T, format string, entries []testEntry,
+) (*tarPayload, *int) {
+	t.Helper()
+	archive := writeTestTar(t, format, entries)
+	passes := -1
+	open := func() (io.ReadCloser, error) {
+		passes++
+		return io.NopCloser(bytes.NewReader(archive)), nil
+	}
+	payload, err := newTarPayload(open, testCompressors[format].decompress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload, &passes
+}
+
+// newPayloadInstaller returns an installer of the payload into target, without a
+// previous installation.
+func newPayloadInstaller(
+	t *testing.T, target string, payload payloadReader,
+) *Installer {
+	t.Helper()
+	config := &Config{Variables: VariableMap{"product": "Test", "version": "1.0"}}
+	installer := NewInstallerTo(target, t.TempDir(), config)
+	installer.previousChecked = true
+	installer.setPayloads([]payloadReader{payload})
+	return installer
+}
+
+// readPayloadFile returns the content of a file in the payload.
+func readPayloadFile(t *testing.T, file *PayloadFile) string {
+	t.Helper()
+	reader, err := file.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading %s: %v", file.Name, err)
+	}
+	return string(content)
+}
+
+// checkInstalledFiles fails the test unless the files in target have the given
+// contents.
+func checkInstalledFiles(t *testing.T, target string, want map[string]string) {
+	t.Helper()
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(target, name))
+		if err != nil {
+			t.Error(err)
+		} else if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+}
+
+func TestTarPayloadFiles(t *testing.T) {
+	for format := range testCompressors {
+		t.Run(format, func(t *testing.T) {
+			payload, _ := newTestTarPayload(t, format, testEntries)
+			files := payload.Files()
+			want := []struct {
+				name string
+				size uint64
+				mode os.FileMode
+			}{
+				{"app/", 0, os.ModeDir},
+				{"app/a", 5, 0},
+				{"app/l", 1, os.ModeSymlink},
+				{"app/h", 5, 0},
+				{"app/b", 4, 0},
+				{"app/c", 5, 0},
+			}
+			if len(files) != len(want) {
+				t.Fatalf("%d files, want %d", len(files), len(want))
+			}
+			for f, file := range files {
+				if file.Name != want[f].name ||
+					file.UncompressedSize64 != want[f].size ||
+					file.Mode().Type() != want[f].mode {
+					t.Errorf(
+						"file %d = %s (%d B, %v), want %s (%d B, %v)", f, file.Name,
+						file.UncompressedSize64, file.Mode().Type(), want[f].name,
+						want[f].size, want[f].mode,
+					)
+				}
+			}
+			if target, _ := files[2].symlinkTarget(); target != "a" {
+				t.Errorf("symlink target = %q, want %q", target, "a")
+			}
+			if files[3].hardLink != files[1] {
+				t.Error("hard link doesn't link to app/a")
+			}
+		})
+	}
+}
+
+func TestTarPayloadRead(t *testing.T) {
+	tests := []struct {
+		name       string
+		read       []int
+		wantPasses int
+	}{
+		{"in order", []int{1, 4, 5}, 1},
+		{"skipping files", []int{5}, 1},
+		{"backwards", []int{5, 1}, 2},
+		{"same file twice", []int{1, 1}, 2},
+		{"hard link", []int{4, 3}, 2},
+	}
+	contents := []string{"", "alpha", "", "alpha", "beta", "gamma"}
+	for format := range testCompressors {
+		for _, test := range tests {
+			t.Run(format+"/"+test.name, func(t *testing.T) {
+				payload, passes := newTestTarPayload(t, format, testEntries)
+				for _, f := range test.read {
+					file := payload.Files()[f]
+					if got := readPayloadFile(t, file); got != contents[f] {
+						t.Errorf("%s = %q, want %q", file.Name, got, contents[f])
+					}
+					checksum, err := file.checksum()
+					if want := crc32.ChecksumIEEE([]byte(contents[f])); err != nil ||
+						checksum != want {
+						t.Errorf(
+							"checksum of %s = %08x, %v, want %08x", file.Name,
+							checksum, err, want,
+						)
+					}
+				}
+				if *passes != test.wantPasses {
+					t.Errorf(
+						"%d passes through the archive, want %d", *passes,
+						test.wantPasses,
+					)
+				}
+			})
+		}
+	}
+}
+
+func TestTarPayloadChecksumMismatch(t *testing.T) {
+	payload, _ := newTestTarPayload(t, "tar.zst", testEntries)
+	file := payload.Files()[1]
+	readPayloadFile(t, file)
+	file.CRC32++ // as if the archive changed since the file was first read
+	reader, err := file.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if _, err := io.ReadAll(reader); !errors.Is(err, ErrPayloadCorrupt) {
+		t.Errorf("error = %v, want ErrPayloadCorrupt", err)
+	}
+}
+
+func TestInstallTarPayload(t *testing.T) {
+	for format := range testCompressors {
+		t.Run(format, func(t *testing.T) {
+			target := t.TempDir()
+			payload, passes := newTestTarPayload(t, format, testEntries)
+			installer := newPayloadInstaller(t, target, payload)
+			err := installer.installFiles(context.Background(), false, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkInstalledFiles(t, target, map[string]string{
+				"app/a": "alpha", "app/h": "alpha", "app/b": "beta", "app/c": "gamma",
+			})
+			link, err := os.Readlink(filepath.Join(target, "app", "l"))
+			if link != "a" {
+				t.Errorf("symlink = %q, %v, want %q", link, err, "a")
+			}
+			a, _ := os.Stat(filepath.Join(target, "app", "a"))
+			h, _ := os.Stat(filepath.Join(target, "app", "h"))
+			if a == nil || h == nil || !os.SameFile(a, h) {
+				t.Error("hard link wasn't installed as a hard link")
+			}
+			if *passes != 1 {
+				t.Errorf("%d passes through the archive, want 1", *passes)
+			}
+		})
+	}
+}
+
+func TestUpdateTarPayload(t *testing.T) {
+	previous := map[string]string{
+		"app/a": "alpha", // unchanged
+		"app/b": "bet4",  // changed, with the same size
+		"app/c": "gam",   // changed size
+	}
+	for format := range testCompressors {
+		for _, staged := range []bool{false, true} {
+			name := format
+			if staged {
+				name += "/staged"
+			}
+			t.Run(name, func(t *testing.T) {
+				target := filepath.Join(t.TempDir(), "target")
+				if err := os.MkdirAll(filepath.Join(target, "app"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				previousFiles := map[string]InstallRecordFile{}
+				for name, content := range previous {
+					path := filepath.Join(target, name)
+					if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+						t.Fatal(err)
+					}
+					previousFiles[name] = InstallRecordFile{
+						Path: name, CRC32: crc32.ChecksumIEEE([]byte(content)),
+					}
+				}
+				payload, passes := newTestTarPayload(t, format, testEntries)
+				installer := newPayloadInstaller(t, target, payload)
+				if staged {
+					if err := installer.startStaging(); err != nil {
+						t.Fatal(err)
+					}
+					if err := installer.carryOverUserFiles(previousFiles); err != nil {
+						t.Fatal(err)
+					}
+				}
+				err := installer.installFiles(
+					context.Background(), true, false, previousFiles,
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				unchanged := map[string]bool{}
+				for _, file := range installer.files {
+					unchanged[file.Target] = file.unchanged
+				}
+				want := map[string]bool{"app/a": true, "app/b": false, "app/c": false}
+				for name, wantUnchanged := range want {
+					if unchanged[name] != wantUnchanged {
+						t.Errorf(
+							"%s unchanged = %v, want %v", name, unchanged[name],
+							wantUnchanged,
+						)
+					}
+				}
+				checkInstalledFiles(t, installer.installDir(), map[string]string{
+					"app/a": "alpha", "app/b": "beta", "app/c": "gamma",
+				})
+				if !staged {
+					checkInstalledFiles(t, installer.backupDir(), map[string]string{
+						"app/b": "bet4", "app/c": "gam",
+					})
+				}
+				if *passes != 1 {
+					t.Errorf("%d passes through the archive, want 1", *passes)
+				}
+				partials := filepath.Join(installer.installDir(), "app", ".*")
+				if matches, _ := filepath.Glob(partials); len(matches) > 0 {
+					t.Errorf("partial files were left behind: %v", matches)
+				}
+			})
+		}
+	}
+}
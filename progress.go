@@ -0,0 +1,268 @@
+package linux_installer
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"time"
+)
+
+// Phases of the installation, as reported in InstallStatus.Phase. PhaseProgress is only
+// reported as ProgressEvent, while a file is copied.
+const (
+	PhasePreInstall      = "pre-install"
+	PhaseFile            = "file"
+	PhaseProgress        = "progress"
+	PhaseVerify          = "verify"
+	PhaseLauncher        = "launcher"
+	PhaseDesktopShortcut = "desktop-shortcut"
+	PhaseUninstaller     = "uninstaller"
+	PhasePostInstall     = "post-install"
+	PhaseDone            = "done"
+	PhaseFailed          = "failed"
+	PhaseRolledBack      = "rolled-back"
+)
+
+// Output formats for the progress of a commandline installation.
+const (
+	progressFormatText = "text"
+	progressFormatJson = "json"
+)
+
+const (
+	// progressEventInterval is the minimum time between two ProgressEvents.
+	progressEventInterval = 250 * time.Millisecond
+	// rateSampleInterval is the minimum time between two samples of the installation's
+	// throughput.
+	rateSampleInterval = 500 * time.Millisecond
+	// rateSmoothingTime is the time constant of the moving average of the throughput. A
+	// longer time gives a steadier rate and ETA, which follow changes more slowly.
+	rateSmoothingTime = 5 * time.Second
+)
+
+// rateMeter measures the throughput of the installation as an exponential moving
+// average of samples of the installed bytes.
+type rateMeter struct {
+	sampled  time.Time
+	bytes    int64
+	rate     float64
+	measured bool
+}
+
+// jsonEvent is the JSON representation of an Event, as printed by printJsonEvent.
+type jsonEvent struct {
+	Phase          string `json:"phase"`
+	File           string `json:"file,omitempty"`
+	Path           string `json:"path,omitempty"`
+	BytesDone      int64  `json:"bytes_done"`
+	BytesTotal     int64  `json:"bytes_total"`
+	BytesPerSecond int64  `json:"bytes_per_second,omitempty"`
+	EtaSeconds     int64  `json:"eta_seconds,omitempty"`
+	Error          string `json:"error,omitempty"`
+	Code           string `json:"code,omitempty"`
+	ExitCode       int    `json:"exit_code,omitempty"`
+}
+
+// setStatus sets the installer's current status, fills in the progress in bytes, passes
+// the status on to the progress function, and emits it as an Event to subscribers.
+func (i *Installer) setStatus(status InstallStatus) {
+	i.statusLock.Lock()
+	progress := i.currentProgress(time.Now())
+	status.BytesDone = progress.Done
+	status.BytesTotal = progress.Total
+	i.status = &status
+	i.statusLock.Unlock()
+	i.progressFunction(status)
+	if event := status.event(progress); event != nil {
+		i.emit(event)
+	}
+}
+
+// Status returns the installer's current status.
+func (i *Installer) Status() InstallStatus {
+	i.statusLock.Lock()
+	defer i.statusLock.Unlock()
+	return *i.status
+}
+
+// Done returns whether the installation finished, failed or was rolled back.
+func (i *Installer) Done() bool {
+	i.statusLock.Lock()
+	defer i.statusLock.Unlock()
+	return i.done
+}
+
+// setDone sets whether the installation is done, see Done().
+func (i *Installer) setDone(done bool) {
+	i.statusLock.Lock()
+	i.done = done
+	i.statusLock.Unlock()
+}
+
+// addProgress adds installed bytes to the progress, and samples the throughput. Must be
+// called with the statusLock held.
+func (i *Installer) addProgress(bytes int64) {
+	i.installedSize += bytes
+	i.rate.sample(i.installedSize, time.Now())
+}
+
+// currentProgress returns the progress in bytes, with the current rate and ETA. Must be
+// called with the statusLock held.
+func (i *Installer) currentProgress(now time.Time) ByteProgress {
+	progress := ByteProgress{Done: i.installedSize, Total: i.totalSize}
+	rate := i.rate.current(i.installedSize, now)
+	if rate >= 1 && progress.Done < progress.Total {
+		progress.Rate = int64(rate)
+		seconds := math.Ceil(float64(progress.Total-progress.Done) / rate)
+		progress.ETA = time.Duration(seconds) * time.Second
+	}
+	return progress
+}
+
+// reset starts measuring the throughput anew, from the given number of installed bytes.
+func (m *rateMeter) reset(bytes int64, now time.Time) {
+	*m = rateMeter{sampled: now, bytes: bytes}
+}
+
+// current returns the throughput in bytes per second, including the bytes installed
+// since the last sample if that is long enough ago. It is 0 until the first sample.
+func (m *rateMeter) current(bytes int64, now time.Time) float64 {
+	elapsed := now.Sub(m.sampled)
+	if m.sampled.IsZero() || elapsed < rateSampleInterval {
+		return m.rate
+	}
+	rate := float64(bytes-m.bytes) / elapsed.Seconds()
+	if !m.measured {
+		return rate
+	}
+	weight := 1 - math.Exp(-elapsed.Seconds()/rateSmoothingTime.Seconds())
+	return m.rate + weight*(rate-m.rate)
+}
+
+// sample adds the number of installed bytes as a sample, if the last sample is at least
+// rateSampleInterval ago.
+func (m *rateMeter) sample(bytes int64, now time.Time) {
+	if m.sampled.IsZero() || now.Sub(m.sampled) < rateSampleInterval {
+		return
+	}
+	m.rate = m.current(bytes, now)
+	m.measured = true
+	m.sampled = now
+	m.bytes = bytes
+}
+
+// errorCode returns a short, stable identifier for an error. For an Error it is its
+// translation key.
+func errorCode(err error) string {
+	var installerErr *Error
+	if errors.As(err, &installerErr) {
+		return installerErr.Key
+	}
+	switch err.(type) {
+	case *os.PathError, *os.LinkError, *os.SyscallError:
+		return "io_error"
+	}
+	return "error"
+}
+
+// printJsonEvent prints an Event to stdout as a single line of JSON. Hook output is not
+// printed.
+func printJsonEvent(event Event) {
+	progress := event.Bytes()
+	output := jsonEvent{
+		BytesDone:      progress.Done,
+		BytesTotal:     progress.Total,
+		BytesPerSecond: progress.Rate,
+		EtaSeconds:     int64(progress.ETA / time.Second),
+	}
+	var err error
+	switch event := event.(type) {
+	case PhaseEvent:
+		output.Phase = event.Phase
+		output.Path = event.Path
+		err = event.Err
+	case FileEvent:
+		output.Phase = event.Phase
+		if event.File != nil {
+			output.File = event.File.Target
+		}
+	case ProgressEvent:
+		output.Phase = PhaseProgress
+		output.File = event.File.Target
+	case FinishedEvent:
+		output.Phase = PhaseDone
+		if event.Err != nil {
+			output.Phase = PhaseFailed
+			output.ExitCode = ExitCode(event.Err)
+		}
+		err = event.Err
+	case RolledBackEvent:
+		output.Phase = PhaseRolledBack
+	default:
+		return
+	}
+	if err != nil {
+		output.Error = err.Error()
+		if cause := errors.Unwrap(err); cause != nil {
+			output.Error = cause.Error()
+		}
+		output.Code = errorCode(err)
+	}
+	json.NewEncoder(os.Stdout).Encode(output)
+}
+
+// event returns the Event that printJsonEvent printed as the jsonEvent, as far as it
+// can be restored: files only have their target, and errors are restored from their
+// code and message, see err().
+func (e jsonEvent) event() Event {
+	progress := ByteProgress{
+		Done:  e.BytesDone,
+		Total: e.BytesTotal,
+		Rate:  e.BytesPerSecond,
+		ETA:   time.Duration(e.EtaSeconds) * time.Second,
+	}
+	switch e.Phase {
+	case PhaseFile, PhaseVerify:
+		return FileEvent{ByteProgress: progress, Phase: e.Phase, File: e.file()}
+	case PhaseProgress:
+		return ProgressEvent{ByteProgress: progress, File: e.file()}
+	case PhaseDone, PhaseFailed:
+		return FinishedEvent{ByteProgress: progress, Err: e.err()}
+	case PhaseRolledBack:
+		return RolledBackEvent{ByteProgress: progress}
+	}
+	return PhaseEvent{
+		ByteProgress: progress, Phase: e.Phase, Path: e.Path, Err: e.err(),
+	}
+}
+
+// file returns the file of the jsonEvent, with only its target set, or nil if it has
+// none.
+func (e jsonEvent) file() *InstallFile {
+	if len(e.File) == 0 {
+		return nil
+	}
+	return &InstallFile{Target: e.File}
+}
+
+// err returns the error of the jsonEvent, or nil if it has none. Codes of an Error are
+// restored as an Error with the same key and exit status, which wraps the message of
+// its underlying error if it had one. Other errors are restored as their message.
+func (e jsonEvent) err() error {
+	if len(e.Code) == 0 {
+		return nil
+	}
+	var cause error
+	if e.Error != e.Code {
+		cause = errors.New(e.Error)
+	}
+	if e.Code == "error" || e.Code == "io_error" {
+		return cause
+	}
+	exit := e.ExitCode
+	if exit == ExitSuccess {
+		exit = ExitError
+	}
+	return &Error{Key: e.Code, Exit: exit, Err: cause}
+}
@@ -0,0 +1,219 @@
+package linux_installer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// InstallRecord describes a finished installation. It is written after a successful
+	// installation and used to detect previous installations of the same product, in
+	// order to update them. All records together form the registry of installed
+	// products, which "-list-installed" prints and "-uninstall" looks up.
+	InstallRecord struct {
+		Product      string              `yaml:"product"`
+		Version      string              `yaml:"version"`
+		Organization string              `yaml:"organization,omitempty"`
+		Target       string              `yaml:"target"`
+		Installed    time.Time           `yaml:"installed"`
+		Uninstaller  string              `yaml:"uninstaller,omitempty"`
+		Language     string              `yaml:"language,omitempty"`
+		Files        []InstallRecordFile `yaml:"files"`
+
+		dir string // records directory the record was loaded from
+	}
+	// InstallRecordFile is a single entry in the file manifest of an InstallRecord. Path
+	// is relative to the installation target.
+	InstallRecordFile struct {
+		Path  string `yaml:"path"`
+		Dir   bool   `yaml:"dir,omitempty"`
+		CRC32 uint32 `yaml:"crc32,omitempty"`
+	}
+)
+
+var recordNameRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// newInstallRecord creates a record of the installer's current installation, listing
+// all files and directories that are part of it. uninstaller is the path of the
+// installation's uninstaller, if there is one.
+func (i *Installer) newInstallRecord(uninstaller string) *InstallRecord {
+	record := &InstallRecord{
+		Product:      i.config.Variables["product"],
+		Version:      i.config.Variables["version"],
+		Organization: i.config.Variables["organization"],
+		Target:       i.Target,
+		Installed:    time.Now(),
+		Uninstaller:  uninstaller,
+		Language:     i.Language,
+		Files:        make([]InstallRecordFile, 0, len(i.files)),
+	}
+	for _, file := range i.files {
+		if file.installed || file.unchanged {
+			// known, since installed and unchanged files were read or checked
+			checksum, _ := file.checksum()
+			record.Files = append(record.Files, InstallRecordFile{
+				Path:  file.Target,
+				Dir:   file.IsDir(),
+				CRC32: checksum,
+			})
+		}
+	}
+	return record
+}
+
+// filename returns the filename of the record inside the records directory. It is
+// derived from the product name and a hash of the target, so there is one record per
+// product and installation directory.
+func (r *InstallRecord) filename() string {
+	hash := fnv.New32a()
+	hash.Write([]byte(r.Target))
+	return fmt.Sprintf("%s-%08x.yml", recordName(r.Product), hash.Sum32())
+}
+
+// recordName returns the product name in lowercase, with anything but letters and
+// digits replaced by dashes. Product names are compared by their record name, so that
+// e.g. "-uninstall my-product" finds "My Product".
+func recordName(product string) string {
+	name := recordNameRegex.ReplaceAllString(strings.ToLower(product), "-")
+	return strings.Trim(name, "-")
+}
+
+// save writes the record into the records directory it was loaded from, or the current
+// user's records directory, replacing any previous record for the same product and
+// target.
+func (r *InstallRecord) save() error {
+	recordDir := r.dir
+	if len(recordDir) == 0 {
+		recordDir = osInstallRecordDir() // os-specific
+	}
+	err := os.MkdirAll(recordDir, 0755)
+	if err != nil {
+		return err
+	}
+	content, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(recordDir, r.filename()), content, 0644)
+}
+
+// remove deletes the record from the records directory it was loaded from, or the
+// current user's records directory.
+func (r *InstallRecord) remove() error {
+	recordDir := r.dir
+	if len(recordDir) == 0 {
+		recordDir = osInstallRecordDir() // os-specific
+	}
+	return os.Remove(filepath.Join(recordDir, r.filename()))
+}
+
+// restoreInstallRecord restores the record of the previous installation in the target
+// when the installation is rolled back, or removes the record that PostInstall() wrote
+// if there was none.
+func (i *Installer) restoreInstallRecord() {
+	var err error
+	if previous := i.PreviousInstall(); previous != nil &&
+		filepath.Clean(previous.Target) == i.Target {
+		err = previous.save()
+	} else {
+		record := &InstallRecord{
+			Product: i.config.Variables["product"], Target: i.Target,
+		}
+		err = record.remove()
+	}
+	if err != nil {
+		slog.Warn("Unable to restore install record", ErrorAttr(err))
+	}
+	i.recordSaved = false
+}
+
+// allUsers returns whether the record was loaded from a system-wide records directory,
+// i.e. the installation is for all users and only root may change it.
+func (r *InstallRecord) allUsers() bool {
+	return len(r.dir) > 0 && r.dir != osInstallRecordDir() // os-specific
+}
+
+// loadInstallRecords returns all readable records for the given product, the most
+// recent installation first. Like LoadRegistry(), this includes the system-wide
+// records, so that an installation for all users is found when the installer runs as
+// the user.
+func loadInstallRecords(product string) (records []*InstallRecord) {
+	for _, recordDir := range osInstallRecordDirs() { // os-specific
+		for _, record := range readInstallRecords(recordDir) {
+			if record.Product == product {
+				records = append(records, record)
+			}
+		}
+	}
+	sortInstallRecords(records)
+	return
+}
+
+// sortInstallRecords sorts records by installation time, the most recent one first.
+func sortInstallRecords(records []*InstallRecord) {
+	sort.Slice(records, func(a, b int) bool {
+		return records[a].Installed.After(records[b].Installed)
+	})
+}
+
+// readInstallRecords returns all readable records in a records directory.
+func readInstallRecords(recordDir string) (records []*InstallRecord) {
+	recordFiles, err := ioutil.ReadDir(recordDir)
+	if err != nil {
+		return
+	}
+	for _, f := range recordFiles {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".yml" {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(recordDir, f.Name()))
+		if err != nil {
+			continue
+		}
+		record := &InstallRecord{}
+		err = yaml.Unmarshal(content, record)
+		if err != nil {
+			slog.Warn(
+				"Unable to parse install record", "path", f.Name(), ErrorAttr(err),
+			)
+			continue
+		}
+		record.dir = recordDir
+		records = append(records, record)
+	}
+	return
+}
+
+// PreviousInstall returns the record of the most recent installation of the same
+// product whose installation directory still exists, or nil if there is none.
+func (i *Installer) PreviousInstall() *InstallRecord {
+	if i.previousChecked {
+		return i.previous
+	}
+	i.previousChecked = true
+	for _, record := range loadInstallRecords(i.config.Variables["product"]) {
+		if info, err := os.Stat(record.Target); err == nil && info.IsDir() {
+			slog.Info("Found previous installation", "path", record.Target)
+			i.previous = record
+			break
+		}
+	}
+	return i.previous
+}
+
+// Updating returns whether the installer will update a previous installation, i.e. if
+// Update is set and the target is the directory of the previous installation.
+func (i *Installer) Updating() bool {
+	previous := i.PreviousInstall()
+	return i.Update && previous != nil && filepath.Clean(previous.Target) == i.Target
+}
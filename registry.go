@@ -0,0 +1,130 @@
+package linux_installer
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+)
+
+// registryDateFormat is the format of installation dates in the "-list-installed"
+// output.
+const registryDateFormat = "2006-01-02 15:04"
+
+// LoadRegistry returns the records of all products installed by any installer, for the
+// current user as well as system-wide. They are sorted by product name, and the most
+// recent installation first.
+func LoadRegistry() (records []*InstallRecord) {
+	for _, recordDir := range osInstallRecordDirs() { // os-specific
+		records = append(records, readInstallRecords(recordDir)...)
+	}
+	sortInstallRecords(records)
+	sort.SliceStable(records, func(a, b int) bool {
+		return recordName(records[a].Product) < recordName(records[b].Product)
+	})
+	return
+}
+
+// findInstallRecords returns the registry record for the installation of a product. If
+// the product is installed more than once, target selects the installation. Otherwise
+// all matching records are returned along with errInstallAmbiguous.
+func findInstallRecords(product, target string) ([]*InstallRecord, error) {
+	if len(target) > 0 {
+		if absTarget, err := filepath.Abs(target); err == nil {
+			target = absTarget
+		}
+	}
+	var matches []*InstallRecord
+	for _, record := range LoadRegistry() {
+		if recordName(record.Product) != recordName(product) {
+			continue
+		}
+		if len(target) > 0 && filepath.Clean(record.Target) != target {
+			continue
+		}
+		matches = append(matches, record)
+	}
+	switch len(matches) {
+	case 0:
+		return nil, errNotInstalled
+	case 1:
+		return matches, nil
+	}
+	return matches, errInstallAmbiguous
+}
+
+// RunListInstalled prints all installed products from the registry as a table.
+func RunListInstalled(translator *Translator) error {
+	records := LoadRegistry()
+	if len(records) == 0 {
+		fmt.Println(translator.Get("registry_empty"))
+		return nil
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, translator.Get("registry_header"))
+	for _, record := range records {
+		fmt.Fprintf(
+			table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			record.Product, record.Version, record.Organization, record.Target,
+			record.Installed.Format(registryDateFormat), record.Language,
+			record.Uninstaller,
+		)
+	}
+	return table.Flush()
+}
+
+// RunUninstallProduct looks up the installation of a product in the registry and runs
+// its uninstaller, passing on yes to skip the confirmation. If the product is installed
+// more than once, target selects the installation.
+//
+// The uninstaller runs in the terminal of the installer. The uninstaller of an
+// installation for all users runs with administrator rights through sudo or pkexec,
+// like InstallElevated(). If it fails, its *exec.ExitError is returned.
+func RunUninstallProduct(
+	product, target string, yes bool, translator *Translator,
+) error {
+	records, err := findInstallRecords(product, target)
+	if err != nil {
+		slog.Error("Unable to find installation", "product", product, ErrorAttr(err))
+		fmt.Println(translator.Get(err.Error()), product)
+		for _, record := range records {
+			fmt.Println("  " + record.Target)
+		}
+		return err
+	}
+	record := records[0]
+	info, err := os.Stat(record.Uninstaller)
+	if len(record.Uninstaller) == 0 || err != nil || info.IsDir() {
+		slog.Error(
+			"Installation has no uninstaller", "path", record.Target,
+			ErrorAttr(errNoUninstaller),
+		)
+		fmt.Println(translator.Get(errNoUninstaller.Error()), record.Target)
+		return errNoUninstaller
+	}
+	args := []string{record.Uninstaller}
+	if yes {
+		args = append(args, "-yes")
+	}
+	if record.allUsers() && !osIsRoot() { // os-specific
+		elevation := osElevationCommand(false) // os-specific
+		if elevation == nil {
+			slog.Error(
+				"Unable to uninstall for all users", "path", record.Target,
+				ErrorAttr(errNoElevationCommand),
+			)
+			fmt.Println(translator.Get(errUninstallNoAdmin.Error()), record.Target)
+			return errUninstallNoAdmin.wrap(errNoElevationCommand)
+		}
+		args = append(elevation, args...)
+	}
+	slog.Info("Running uninstaller", "path", record.Uninstaller, "cmd", args)
+	uninstaller := exec.Command(args[0], args[1:]...)
+	uninstaller.Stdin = os.Stdin
+	uninstaller.Stdout = os.Stdout
+	uninstaller.Stderr = os.Stderr
+	return uninstaller.Run()
+}
@@ -0,0 +1,149 @@
+package linux_installer
+
+import (
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// UnmetRequirement is a system requirement from the config which the system doesn't
+// meet. Key is the requirement's key in the config, e.g. "min_kernel". Required is the
+// required value, and Found what the system has instead, e.g. its kernel version. For
+// libraries and executables, Required lists the missing ones. Warning is set if the
+// requirement only warns, see Requirements.Warn.
+type UnmetRequirement struct {
+	Key      string
+	Required string
+	Found    string
+	Warning  bool
+}
+
+// Message returns a localized message for the unmet requirement, from the language
+// string "requirement_" + Key.
+func (r UnmetRequirement) Message(translator *Translator) string {
+	return translator.GetWith(
+		"requirement_"+r.Key, VariableMap{"required": r.Required, "found": r.Found},
+	)
+}
+
+// CheckRequirements checks the system requirements from the config, and returns the
+// ones the system doesn't meet. If any of them is not just a warning, it also returns
+// ErrRequirementsNotMet. Requirements that can't be checked on this system, e.g.
+// because the kernel version is unknown, count as met. The free disk space is checked
+// along with the target, see DiskSpaceSufficient().
+func (i *Installer) CheckRequirements() (unmet []UnmetRequirement, err error) {
+	r := i.config.Requirements
+	check := func(key string, met bool, required string, found string) {
+		if met {
+			return
+		}
+		warning := r.warns(key)
+		slog.Warn(
+			"Requirement not met", "requirement", key, "required", required,
+			"found", found, "warning", warning,
+		)
+		unmet = append(unmet, UnmetRequirement{key, required, found, warning})
+		if !warning {
+			err = ErrRequirementsNotMet
+		}
+	}
+	if len(r.Arch) > 0 {
+		machine := osMachine() // os-specific
+		met := len(machine) == 0 || contains(r.Arch, machine)
+		check("arch", met, strings.Join(r.Arch, ", "), machine)
+	}
+	if len(r.MinKernel) > 0 {
+		kernel := osKernelVersion() // os-specific
+		met := len(kernel) == 0 || versionAtLeast(kernel, r.MinKernel)
+		check("min_kernel", met, r.MinKernel, kernel)
+	}
+	if len(r.MinGlibc) > 0 {
+		glibc := osGlibcVersion() // os-specific
+		met := len(glibc) == 0 || versionAtLeast(glibc, r.MinGlibc)
+		check("min_glibc", met, r.MinGlibc, glibc)
+	}
+	var missingLibraries, missingExecutables []string
+	for _, library := range r.Libraries {
+		if !osLibraryAvailable(library) { // os-specific
+			missingLibraries = append(missingLibraries, library)
+		}
+	}
+	check(
+		"libraries", len(missingLibraries) == 0, strings.Join(missingLibraries, ", "), "",
+	)
+	for _, executable := range r.Executables {
+		if _, lookErr := exec.LookPath(executable); lookErr != nil {
+			missingExecutables = append(missingExecutables, executable)
+		}
+	}
+	check(
+		"executables", len(missingExecutables) == 0,
+		strings.Join(missingExecutables, ", "), "",
+	)
+	if r.MinMemoryMB > 0 {
+		memory := osMemory() // os-specific
+		required := r.MinMemoryMB * 1024 * 1024
+		met := memory == 0 || memory >= required
+		check("min_memory_mb", met, sizeString(required), sizeString(memory))
+	}
+	return
+}
+
+// warns returns whether the requirement with the given key only warns if it isn't met.
+func (r *Requirements) warns(key string) bool {
+	return contains(r.Warn, key)
+}
+
+// freeSpace returns the disk space in bytes that must remain free after the
+// installation.
+func (r *Requirements) freeSpace() int64 {
+	return r.FreeSpaceMB * 1024 * 1024
+}
+
+// contains returns whether the list contains the string.
+func contains(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
+
+// versionAtLeast returns whether version is the same as or newer than minimum. Only
+// the leading dot-separated numbers are compared, so that "5.4.0-42-generic" is at
+// least "5.4", and missing numbers count as 0.
+func versionAtLeast(version string, minimum string) bool {
+	v, m := versionNumbers(version), versionNumbers(minimum)
+	for n := 0; n < len(v) || n < len(m); n++ {
+		var vn, mn int
+		if n < len(v) {
+			vn = v[n]
+		}
+		if n < len(m) {
+			mn = m[n]
+		}
+		if vn != mn {
+			return vn > mn
+		}
+	}
+	return true
+}
+
+// versionNumbers returns the leading dot-separated numbers of a version string, e.g.
+// [5 4 0] for "5.4.0-42-generic".
+func versionNumbers(version string) (numbers []int) {
+	for _, part := range strings.Split(strings.TrimSpace(version), ".") {
+		digits := len(part) - len(strings.TrimLeft(part, "0123456789"))
+		if digits == 0 {
+			break
+		}
+		number, _ := strconv.Atoi(part[:digits])
+		numbers = append(numbers, number)
+		if digits < len(part) {
+			break
+		}
+	}
+	return
+}
@@ -1,14 +1,18 @@
 package linux_installer
 
 import (
+	"archive/zip"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/GeertJohan/go.rice"
 )
@@ -21,7 +25,8 @@ type BoxFile struct {
 var resourcesBox *rice.Box
 var dataBox *rice.Box
 
-// openBoxes opens all payload boxes.
+// openBoxes opens all payload boxes. The uninstaller has no data box (see
+// copyExecutable()), so a missing data box is only an error once it is used.
 //
 // For go.rice's 'append' mode to work, all calls to FindBox() have to have a literal
 // string parameter. If you update directory names here, update builder/rice.go as well!
@@ -33,7 +38,8 @@ func openBoxes() {
 	}
 	dataBox, err = rice.FindBox("data-compressed")
 	if err != nil {
-		panic(err)
+		slog.Debug("No data box", ErrorAttr(err))
+		dataBox = nil
 	}
 }
 
@@ -74,10 +80,155 @@ func GetResourceFiltered(name string, dirFilter *regexp.Regexp) (map[string]stri
 // written to, or anything else goes wrong.
 func UnpackResourceDir(from string, to string) error { return unpackDir(resourcesBox, from, to) }
 
-// UnpackDataDir copies all data files from a subdir given by from to a path given by
-// to. It returns an error if the boxes aren't opened yet, the path can't be written to,
-// or anything else goes wrong.
-func UnpackDataDir(from string, to string) error { return unpackDir(dataBox, from, to) }
+// dataFileNames returns the names of all files at the top level of the data box, i.e.
+// the payload archives.
+func dataFileNames() (names []string, err error) {
+	if dataBox == nil {
+		return nil, errors.New("Boxes not opened yet.")
+	}
+	root, err := dataBox.Open("")
+	if err != nil {
+		return
+	}
+	defer root.Close()
+	infos, err := root.Readdir(0)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		if !info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// openDataFile opens a file in the data box for reading. Files of an appended data box
+// are read directly from the executable, see openAppendedDataFile().
+func openDataFile(name string) (io.ReadCloser, error) {
+	section, file, err := openAppendedDataFile(name)
+	if err != nil {
+		return nil, err
+	} else if section != nil {
+		return &sectionFile{SectionReader: section, file: file}, nil
+	}
+	if dataBox == nil {
+		return nil, errors.New("Boxes not opened yet.")
+	}
+	return dataBox.Open(name)
+}
+
+// openDataZip opens a zip file in the data box for reading, without unpacking it. The
+// file stays open as long as the zip file is in use.
+func openDataZip(name string) (*zip.Reader, error) {
+	section, file, err := openAppendedDataFile(name)
+	if err != nil {
+		return nil, err
+	} else if section != nil {
+		zipReader, err := zip.NewReader(section, section.Size())
+		if err != nil {
+			file.Close()
+		}
+		return zipReader, err
+	}
+	if dataBox == nil {
+		return nil, errors.New("Boxes not opened yet.")
+	}
+	boxFile, err := dataBox.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := boxFile.Stat()
+	if err != nil {
+		boxFile.Close()
+		return nil, err
+	}
+	zipReader, err := zip.NewReader(&boxReaderAt{file: boxFile}, info.Size())
+	if err != nil {
+		boxFile.Close()
+	}
+	return zipReader, err
+}
+
+// openAppendedDataFile opens a file of the data box directly in the zip archive which
+// "rice append" appended to the executable. go.rice reads appended files into memory,
+// which payloads of several gigabytes don't fit into. Appended files are stored
+// uncompressed, at absolute offsets in the executable, so the file is a section of it.
+// The executable is returned as well, to be closed once the section isn't needed
+// anymore. If nothing is appended to the executable, e.g. during development, both are
+// nil, and the file has to be read from the data box instead.
+func openAppendedDataFile(name string) (*io.SectionReader, *os.File, error) {
+	executable, err := os.Executable()
+	if err == nil {
+		executable, err = filepath.EvalSymlinks(executable)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(executable)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	archive, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, nil, nil
+	}
+	boxPath := path.Join(payloadBoxNames[1], name)
+	for _, entry := range archive.File {
+		if strings.TrimLeft(filepath.ToSlash(entry.Name), "/") != boxPath {
+			continue
+		}
+		offset, err := entry.DataOffset()
+		if err == nil && entry.Method != zip.Store {
+			err = fmt.Errorf("%s is compressed in the executable", boxPath)
+		}
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return io.NewSectionReader(file, offset, int64(entry.CompressedSize64)), file, nil
+	}
+	file.Close()
+	return nil, nil, &os.PathError{Op: "open", Path: boxPath, Err: os.ErrNotExist}
+}
+
+// sectionFile reads a section of a file, and closes the whole file.
+type sectionFile struct {
+	*io.SectionReader
+	file *os.File
+}
+
+// Close implements io.Closer.
+func (f *sectionFile) Close() error { return f.file.Close() }
+
+// boxReaderAt reads a box file at arbitrary offsets, which zip.Reader needs. Box files
+// can only seek and read, so reads are serialized.
+type boxReaderAt struct {
+	file *rice.File
+	lock sync.Mutex
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *boxReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, err := r.file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.file, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
 
 // getBoxContent returns the content of a file given by name inside a given rice box.
 func getBoxContent(box *rice.Box, name string) (string, error) {
@@ -129,7 +280,7 @@ func unpackFile(box *rice.Box, fromPath string, toPath string) error {
 	defer from.Close()
 	err = os.MkdirAll(path.Dir(toPath), 0755)
 	if err != nil {
-		log.Println(fmt.Sprintf("%s %s", err, toPath))
+		slog.Error("Unable to create directory", "path", toPath, ErrorAttr(err))
 		return err
 	}
 	to, err := os.OpenFile(toPath, os.O_RDWR|os.O_CREATE, 0644)
@@ -152,7 +303,7 @@ func unpackDir(box *rice.Box, fromPath string, toPath string) error {
 	}
 	err := os.MkdirAll(toPath, 0755)
 	if err != nil {
-		log.Println(fmt.Sprintf("%s %s", err, toPath))
+		slog.Error("Unable to create directory", "path", toPath, ErrorAttr(err))
 		return err
 	}
 	err = box.Walk(fromPath, func(path string, info os.FileInfo, err error) error {
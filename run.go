@@ -1,11 +1,13 @@
 package linux_installer
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"plugin"
@@ -16,42 +18,104 @@ const (
 	// Linux terminal command string to clear the current line and reset the cursor
 	clearLineVT100         = "\033[2K\r"
 	cliInstallerMaxLineLen = 80
-	logFilename            = "installer.log"
 )
 
 // Run parses commandline options (if any) and starts one of two installer modes,
 // GUI or commandline mode.
 //
 // Commandline parameters are:
-//   -target   // Target directory to install to
-//   -license  // Print the software license and exit
-//   -accept   // Accept the license. (This flag is only available if
-//             // "must_accept_license_on_cli" is set in the config file.)
-//   -lang     // Choose install language. This also affects the GUI mode.
-//   -run      // Run installed application after successful install.
+//   -target     // Target directory to install to
+//   -license    // Print the software license and exit
+//   -accept     // Accept the license. (This flag is only available if
+//               // "must_accept_license_on_cli" is set in the config file.)
+//   -lang       // Choose install language. This also affects the GUI mode.
+//   -run        // Run installed application after successful install.
+//   -desktop-shortcut
+//               // Create a shortcut on the desktop in addition to the launcher entry.
+//   -components // Comma-separated list of component IDs to install instead of the
+//               // default ones. (Only available if components are configured.)
+//   -type       // Installation type ID. (Only available if installation types are
+//               // configured.)
+//   -answers    // Install in commandline mode with the choices from an answer file.
+//               // Other flags given explicitly override the answers, also when
+//               // set to false, e.g. -desktop-shortcut=false.
+//   -record-answers
+//               // Write the choices made in the GUI to an answer file.
+//   -progress   // Progress output format for commandline mode, "text" or "json". The
+//               // json format prints one JSON object per line for each step.
+//   -list-installed
+//               // List all products installed by any installer, and exit.
+//   -uninstall  // Run the uninstaller of the given installed product, and exit. If the
+//               // product is installed more than once, -target selects which one.
+//   -yes        // Uninstall without asking for confirmation (with -uninstall).
+//   -verify     // Only verify the signature of the installer's payload, and exit.
+//   -repair     // Check the installation in the given directory against the payload,
+//               // and write missing or damaged files again.
+//   -no-resume  // Clean up an unfinished installation in the target, instead of
+//               // resuming it.
+//   -tempdir    // Directory in which to create the installer's private temporary
+//               // directory, instead of $TMPDIR or /tmp.
+//   -log        // Path of the log file, instead of "log_filename" from the config file.
+//   -log-format // Format of the log file, "text" or "json", instead of "log_format"
+//               // from the config file.
+//   -verbose    // Also write the log to stderr.
+//   -debug      // Also write the log to stderr, including debug messages.
+//   -all-users  // Install for all users, with administrator rights through sudo or
+//               // pkexec.
+//   -polkit-policy
+//               // Print a polkit action for running this installer with pkexec, and
+//               // exit.
+//   -elevated   // Internal: set by the installer that runs itself for -all-users, and
+//               // left out of the -help output.
+//
+// If the installer binary is run as the uninstaller inside an installation directory
+// (see RunUninstall()), the only parameters are:
+//   -yes        // Uninstall without asking for confirmation.
+//   -tempdir    // As above.
+//   -log, -log-format, -verbose, -debug
+//               // As above.
 //
 // Giving any commandline parameters other than -lang will trigger commandline, or
 // "silent" mode. -target (and -accept if configured) are necessary to run commandline
 // install.
 // -lang will also set the default GUI language. It cannot, however, set the language of
 // the -help output.
+//
+// The returned exit status is one of the Exit* constants.
 func Run() int {
-	logfile := startLogging(logFilename)
-	defer logfile.Close()
+	logfile := startLogging()
+	defer logfile.close()
 
 	openBoxes()
 	config, err := NewConfig()
 	if err != nil {
-		return 1
+		return ExitError
 	}
 	config.Variables["installerName"] = os.Args[0]
 	translator := NewTranslatorVar(config.Variables)
 	if translator == nil {
-		log.Println("No language files available")
-		return 5
+		slog.Error("No language files available")
+		return ExitNoLanguages
+	}
+	tempDirParent := flag.String("tempdir", "", translator.Get("cli_help_tempdir"))
+	logFile := flag.String("log", "", translator.Get("cli_help_log"))
+	logFormat := flag.String("log-format", "", translator.Get("cli_help_log_format")+" "+logFormatText+", "+logFormatJson)
+	verbose := flag.Bool("verbose", false, translator.Get("cli_help_verbose"))
+	debug := flag.Bool("debug", false, translator.Get("cli_help_debug"))
+
+	if manifestPath := uninstallManifestPath(); len(manifestPath) > 0 {
+		yes := flag.Bool("yes", false, translator.Get("cli_help_yes"))
+		flag.Parse()
+		openLog(logfile, config, *logFile, *logFormat, *verbose, *debug)
+		temp, err := createTempDir(*tempDirParent)
+		if err != nil {
+			printTempDirError(err, translator)
+			return ExitCode(err)
+		}
+		defer temp.remove()
+		err = RunUninstall(temp.path, manifestPath, *yes, translator, config)
+		return ExitCode(err)
 	}
-	installerTempPath := filepath.Join(os.TempDir(), "linux_installer")
-	defer os.RemoveAll(installerTempPath)
 
 	target := flag.String("target", "", translator.Get("cli_help_target"))
 	showLicense := flag.Bool("license", false, translator.Get("cli_help_showlicense"))
@@ -60,9 +124,64 @@ func Run() int {
 		acceptLicense = flag.Bool("accept", false, translator.Get("cli_help_acceptlicense"))
 	}
 	noLauncher := flag.Bool("no-launcher", false, translator.Get("cli_help_nolauncher"))
+	desktopShortcut := flag.Bool("desktop-shortcut", false, translator.Get("cli_help_desktop_shortcut"))
 	runInstalled := flag.Bool("run", false, translator.Get("cli_help_run_installed"))
 	lang := flag.String("lang", "", translator.Get("cli_help_lang")+" "+strings.Join(translator.GetLanguages(), ", "))
+	var components *string
+	if len(config.Components) > 0 {
+		componentIds := make([]string, 0, len(config.Components))
+		for _, c := range config.Components {
+			componentIds = append(componentIds, c.Id)
+		}
+		components = flag.String("components", "", translator.Get("cli_help_components")+" "+strings.Join(componentIds, ", "))
+	}
+	var installType *string
+	if len(config.InstallTypes) > 0 {
+		installTypeIds := make([]string, 0, len(config.InstallTypes))
+		for _, t := range config.InstallTypes {
+			installTypeIds = append(installTypeIds, t.Id)
+		}
+		installType = flag.String("type", "", translator.Get("cli_help_type")+" "+strings.Join(installTypeIds, ", "))
+	}
+	answersFile := flag.String("answers", "", translator.Get("cli_help_answers"))
+	recordAnswersFile := flag.String("record-answers", "", translator.Get("cli_help_record_answers"))
+	progressFormat := flag.String("progress", progressFormatText, translator.Get("cli_help_progress")+" "+progressFormatText+", "+progressFormatJson)
+	listInstalled := flag.Bool("list-installed", false, translator.Get("cli_help_list_installed"))
+	uninstallProduct := flag.String("uninstall", "", translator.Get("cli_help_uninstall"))
+	yes := flag.Bool("yes", false, translator.Get("cli_help_yes"))
+	verify := flag.Bool("verify", false, translator.Get("cli_help_verify"))
+	repair := flag.String("repair", "", translator.Get("cli_help_repair"))
+	noResume := flag.Bool("no-resume", false, translator.Get("cli_help_no_resume"))
+	allUsers := flag.Bool("all-users", false, translator.Get("cli_help_all_users"))
+	printPolkitPolicy := flag.Bool("polkit-policy", false, translator.Get("cli_help_polkit_policy"))
+	elevated := flag.Bool(elevatedFlag, false, "")
+	flag.Usage = printUsage
 	flag.Parse()
+	// flags given explicitly override the answers, also when set to false
+	flagSet := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { flagSet[f.Name] = true })
+	config.Elevated = *elevated
+	openLog(logfile, config, *logFile, *logFormat, *verbose, *debug)
+
+	temp, err := createTempDir(*tempDirParent)
+	if err != nil {
+		printTempDirError(err, translator)
+		return ExitCode(err)
+	}
+	defer temp.remove()
+	installerTempPath := temp.path
+
+	var answers *Answers
+	if len(*answersFile) > 0 {
+		answers, err = LoadAnswers(*answersFile, config)
+		if err != nil {
+			fmt.Println(translator.Get(err.Error()), *answersFile)
+			return ExitCode(err)
+		}
+		if len(*lang) == 0 {
+			*lang = answers.Language
+		}
+	}
 
 	if len(*lang) > 0 {
 		err := translator.SetLanguage(*lang)
@@ -77,37 +196,123 @@ func Run() int {
 		)
 		if err != nil {
 			fmt.Println(err)
-			return 2
+			return ExitError
 		} else {
 			fmt.Print(licenseFile)
-			return 0
+			return ExitSuccess
+		}
+	}
+
+	if *printPolkitPolicy {
+		policy, err := PolkitPolicy(translator)
+		if err != nil {
+			fmt.Println(err)
+			return ExitError
+		}
+		fmt.Print(policy)
+		return ExitSuccess
+	}
+
+	if *listInstalled {
+		return ExitCode(RunListInstalled(translator))
+	}
+	if len(*uninstallProduct) > 0 {
+		err = RunUninstallProduct(*uninstallProduct, *target, *yes, translator)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		return ExitCode(err)
+	}
+
+	if *verify || PayloadSigned() {
+		err = VerifyPayload()
+		if err != nil {
+			if *progressFormat == progressFormatJson {
+				printJsonEvent(FinishedEvent{Err: err})
+			} else {
+				fmt.Println(ErrorMessage(err, translator))
+			}
+			guiMode := !*verify && len(*target) == 0 && len(*repair) == 0 &&
+				answers == nil
+			if guiMode {
+				osShowRawErrorDialog(translator.Get(err.Error()))
+			}
+			return ExitCode(err)
+		}
+		if *verify {
+			fmt.Println(translator.Get("payload_verified"))
+			return ExitSuccess
 		}
 	}
 
-	config.NoLauncher = *noLauncher
-	config.RunInstalled = *runInstalled
+	if answers != nil {
+		if !answers.VersionMatches(config) {
+			slog.Warn("Answers are for another version", "version", answers.Version)
+			fmt.Println(translator.Get("answers_warn_version"), answers.Version)
+		}
+		answers.apply(config)
+		if len(*target) == 0 {
+			*target = answers.Target
+		}
+	}
+	if flagSet["no-launcher"] {
+		config.NoLauncher = *noLauncher
+	}
+	if flagSet["desktop-shortcut"] {
+		config.DesktopShortcut = *desktopShortcut
+	}
+	if flagSet["run"] {
+		config.RunInstalled = *runInstalled
+	}
+	config.RecordAnswersFile = *recordAnswersFile
+	config.NoResume = *noResume
+	if flagSet["all-users"] {
+		config.AllUsers = *allUsers
+	}
+	if *progressFormat != progressFormatText && *progressFormat != progressFormatJson {
+		fmt.Printf("Progress format '%s' not available\n", *progressFormat)
+		return ExitUsage
+	}
+	config.ProgressFormat = *progressFormat
+	if components != nil && len(*components) > 0 {
+		config.ComponentSelection = strings.Split(*components, ",")
+	}
+	if installType != nil && len(*installType) > 0 {
+		config.InstallTypeSelection = *installType
+	}
 
+	if len(*repair) > 0 {
+		config.Repair = true
+		return ExitCode(RunCliInstall(installerTempPath, *repair, translator, config))
+	}
 	if len(*target) > 0 {
-		if (config.MustAcceptLicense && *acceptLicense) || !config.MustAcceptLicense {
-			RunCliInstall(installerTempPath, *target, translator, config)
+		licenseAccepted := answers != nil && answers.AcceptLicense
+		if flagSet["accept"] {
+			licenseAccepted = *acceptLicense
+		}
+		if !config.MustAcceptLicense || licenseAccepted {
+			err = RunCliInstall(installerTempPath, *target, translator, config)
 		} else {
-			fmt.Println(translator.Get("err_cli_mustacceptlicense"))
+			err = ErrLicenseNotAccepted
+			printCliError(err, *target, translator, config)
 		}
-		return 3
+		return ExitCode(err)
 	}
 
 	err = RunGuiInstall(installerTempPath, translator, config)
-	if err != nil {
-		return 4
+	if errors.Is(err, errGuiFailed) {
+		return ExitGuiFailed
 		// err = RunTuiInstall(installerTempPath, translator)
 	}
-	return 0
+	return ExitCode(err)
 }
 
-// RunGuiInstall loads the gui.so plugin, and starts the installer GUI.
+// RunGuiInstall loads the gui.so plugin, and starts the installer GUI. Once the GUI is
+// closed, the error of the installation is returned, if it failed or was aborted.
 //
-// If the GUI can't be loaded for some reason, an error is returned. Most common reasons
-// for error include (on Linux):
+// If the GUI can't be loaded for some reason, errGuiFailed is returned. Most common
+// reasons for error include (on Linux):
 //
 //  * no desktop running (headless servers, remote logins)
 //  * GTK3 missing (Redhat/Centos 6 or older)
@@ -123,63 +328,267 @@ func RunGuiInstall(
 	UnpackResourceDir("gui", filepath.Join(installerTempPath, "gui"))
 	NewGui, RunGui, err := loadGuiPlugin(installerTempPath, translator)
 	if err != nil {
-		return
+		return errGuiFailed.wrap(err)
 	}
 	installer := NewInstaller(installerTempPath, config)
+	installer.CreateLauncher = !config.NoLauncher
+	installer.CreateDesktopShortcut = config.DesktopShortcut
+	installer.Elevate = config.AllUsers && CanElevate(true)
 	err = NewGui(installerTempPath, installer, translator, config)
 	if err != nil {
 		handleGuiErr(translator.Get("err_gui_startup_failed"), err)
-		return
-	} else {
-		RunGui()
+		return errGuiFailed.wrap(err)
 	}
-	return
+	RunGui()
+	return installer.Error()
 }
 
 // RunCliInstall runs a "silent" installation, in the terminal with no further user
-// interaction.
+// interaction. If config.Repair is set, the installation in the target is repaired
+// instead. If config.AllUsers is set, the installation is run with administrator rights
+// by a copy of the installer (see InstallElevated()). Returns the error that caused the
+// installation to fail, if any.
 func RunCliInstall(
 	installerTempPath, target string, translator *Translator, config *Config,
-) {
+) error {
 	installer := NewInstallerTo(target, installerTempPath, config)
+	installer.Elevate = config.AllUsers && !osIsRoot() // os-specific
+	if !config.Repair {
+		err := checkCliRequirements(installer, translator, config)
+		if err != nil {
+			return err
+		}
+	}
 	err := installer.CheckSetInstallDir(target)
 	if err != nil {
-		log.Println(translator.Get(err.Error()), target)
-		fmt.Println(translator.Get(err.Error()))
-		return
+		printCliError(err, target, translator, config)
+		return err
+	}
+	err = installer.prepareDataFiles()
+	if err != nil {
+		printCliError(err, target, translator, config)
+		return err
+	}
+	if len(config.InstallTypeSelection) > 0 {
+		err = installer.SetInstallType(config.InstallTypeSelection)
+		if err != nil {
+			printCliError(err, config.InstallTypeSelection, translator, config)
+			return err
+		}
+	}
+	if config.ComponentSelection != nil {
+		err = installer.SetComponents(config.ComponentSelection)
+		if err != nil {
+			printCliError(err, config.ComponentSelection, translator, config)
+			return err
+		}
+	}
+	if !installer.DiskSpaceSufficient() {
+		err = ErrNotEnoughSpace
+		printCliError(err, installer.SizeString(), translator, config)
+		return err
 	}
 	installer.CreateLauncher = !config.NoLauncher
-	cancelChannel := make(chan os.Signal, 1)
-	signal.Notify(cancelChannel, os.Interrupt)
-	installer.SetProgressFunction(func(status InstallStatus) {
-		file := installer.NextFile().Target
-		if len(file) > cliInstallerMaxLineLen {
-			file = "..." + file[len(file)-(cliInstallerMaxLineLen-3):]
-		}
-		fmt.Print(clearLineVT100 + file)
-	})
-	fmt.Println(translator.Get("silent_installing"))
-	installer.PreInstall()
-	installer.StartInstall()
+	installer.CreateDesktopShortcut = config.DesktopShortcut
+	installer.Update = !config.NoUpdate
+	installer.Repair = config.Repair
+	installer.Language = translator.GetLanguage()
+	if installer.Repair && !installer.Repairing() {
+		err = errNotInstalled.wrap(errors.New(installer.Target))
+		printCliError(err, target, translator, config)
+		return err
+	}
+	resuming := false
+	if !installer.Repair && !config.NoResume && installer.Resumable() {
+		err = installer.ResumeUnfinished()
+		if err != nil {
+			printCliError(err, target, translator, config)
+			return err
+		}
+		resuming = true
+	}
+	jsonProgress := config.ProgressFormat == progressFormatJson
+	if !jsonProgress {
+		if !resuming && installer.UnfinishedInstall() != nil {
+			fmt.Println(translator.Get("silent_cleaning_up"))
+		}
+		if resuming {
+			fmt.Println(translator.Get("silent_resuming"))
+		} else if installer.Repairing() {
+			fmt.Println(translator.Get("silent_repairing"))
+		} else if installer.Updating() {
+			fmt.Println(translator.Get("silent_updating"))
+		} else {
+			fmt.Println(translator.Get("silent_installing"))
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if config.Elevated {
+		ctx, stop = cancelOnStdinClosed(ctx)
+		defer stop()
+	}
+	events := installer.Subscribe()
+	printed := make(chan struct{})
 	go func() {
-		for range cancelChannel {
-			installer.Rollback()
+		defer close(printed)
+		for event := range events {
+			if jsonProgress {
+				printJsonEvent(event)
+			} else {
+				printTextEvent(event, installer, translator)
+			}
 		}
 	}()
-	installer.WaitForDone()
-	if installer.Error() != nil {
-		log.Println(installer.Error())
-		fmt.Println(translator.Get("silent_failed"))
+	if installer.Elevate {
+		err = installer.InstallElevated(
+			ctx, false, translator.Variables, translator.GetAllStringsRaw(),
+		)
 	} else {
-		installer.PostInstall(
+		err = installer.PreInstall(
 			translator.Variables,
 			translator.GetAllStringsRaw(),
 		)
-		fmt.Println(clearLineVT100 + installer.SizeString())
-		fmt.Println(translator.Get("silent_done"))
-		if config.RunInstalled {
-			installer.ExecInstalled()
+		if err == nil {
+			err = installer.InstallContext(ctx)
 		}
+		if err == nil {
+			err = installer.PostInstall(
+				translator.Variables,
+				translator.GetAllStringsRaw(),
+			)
+		}
+	}
+	<-printed
+	if err != nil {
+		if !errors.Is(err, ErrAborted) {
+			slog.Error("Commandline installation failed", ErrorAttr(err))
+		}
+		return err
+	}
+	if config.RunInstalled {
+		installer.ExecInstalled()
+	}
+	return nil
+}
+
+// checkCliRequirements checks the system requirements before a commandline
+// installation, and prints the ones that aren't met. Returns ErrRequirementsNotMet if
+// any of them prevents the installation.
+func checkCliRequirements(
+	installer *Installer, translator *Translator, config *Config,
+) error {
+	unmet, err := installer.CheckRequirements()
+	if config.ProgressFormat != progressFormatJson {
+		for _, requirement := range unmet {
+			message := requirement.Message(translator)
+			if requirement.Warning {
+				message = translator.Get("requirements_warning") + " " + message
+			}
+			fmt.Println(message)
+		}
+	}
+	if err != nil {
+		keys := make([]string, 0, len(unmet))
+		for _, requirement := range unmet {
+			if !requirement.Warning {
+				keys = append(keys, requirement.Key)
+			}
+		}
+		printCliError(err, keys, translator, config)
+	}
+	return err
+}
+
+// printTextEvent prints the progress of a commandline installation for humans: the file
+// that is being installed, overwritten on the same line, and the result.
+func printTextEvent(event Event, installer *Installer, translator *Translator) {
+	switch event := event.(type) {
+	case FileEvent:
+		if event.Phase != PhaseFile || event.File == nil {
+			return
+		}
+		printProgressLine(event.File.Target, event.ByteProgress, translator)
+	case ProgressEvent:
+		printProgressLine(event.File.Target, event.ByteProgress, translator)
+	case FinishedEvent:
+		if event.Err != nil {
+			fmt.Println(clearLineVT100 + ErrorMessage(event.Err, translator))
+			fmt.Println(translator.Get("silent_failed"))
+		} else {
+			fmt.Println(clearLineVT100 + installer.SizeString())
+			fmt.Println(translator.Get("silent_done"))
+		}
+	case RolledBackEvent:
+		fmt.Print(clearLineVT100)
+	}
+}
+
+// printProgressLine prints the percentage, rate and ETA of the installation followed by
+// the file being installed, overwriting the current line. Long file names are shortened
+// at the front, so that the line fits into cliInstallerMaxLineLen.
+func printProgressLine(file string, progress ByteProgress, translator *Translator) {
+	status := fmt.Sprintf("%3.0f%%", progress.Fraction()*100)
+	if progress.Rate > 0 {
+		status += "  " + progress.RateString()
+	}
+	if progress.ETA > 0 {
+		status += "  " + progress.ETAString() + " " + translator.Get("progress_remaining")
+	}
+	maxLen := cliInstallerMaxLineLen - len(status) - 1
+	if len(file) > maxLen {
+		file = "..." + file[len(file)-(maxLen-3):]
+	}
+	fmt.Print(clearLineVT100 + status + " " + file)
+}
+
+// openLog opens the log file from the -log flag or the config, in the format from the
+// -log-format flag or the config, and mirrors it to stderr with -verbose or -debug. Its
+// path is available to messages as the "logFile" variable. The elevated installer (see
+// InstallElevated()) logs to a new temporary file instead, and writes all records to
+// stderr as JSON.
+func openLog(
+	logfile *logFile, config *Config, path string, format string,
+	verbose bool, debug bool,
+) {
+	if len(format) == 0 {
+		format = config.LogFormat
+	}
+	var mirror slog.Leveler
+	if debug {
+		mirror = slog.LevelDebug
+	} else if verbose {
+		mirror = slog.LevelInfo
+	}
+	config.LogFormat = format
+	path = logPath(path, config)
+	if config.Elevated {
+		// never open a path the user chose as root, see mergeElevatedLog()
+		path, mirror = "", nil
+		logfile.forward = os.Stderr
+	}
+	config.LogPath = logfile.open(path, format, mirror)
+	config.LogStart = logfile.start
+	config.Variables["logFile"] = config.LogPath
+}
+
+// printTempDirError logs and prints the error if the temporary directory can't be
+// created, which prevents any kind of installation.
+func printTempDirError(err error, translator *Translator) {
+	slog.Error("Unable to create temporary directory", ErrorAttr(err))
+	fmt.Println(ErrorMessage(err, translator))
+}
+
+// printCliError logs an error that prevents a commandline installation, along with the
+// detail that caused it, and prints the error in the chosen progress format.
+func printCliError(
+	err error, detail interface{}, translator *Translator, config *Config,
+) {
+	slog.Error("Unable to install", "detail", detail, ErrorAttr(err))
+	if config.ProgressFormat == progressFormatJson {
+		printJsonEvent(FinishedEvent{Err: err})
+	} else {
+		fmt.Println(ErrorMessage(err, translator))
 	}
 }
 
@@ -194,18 +603,6 @@ func RunTuiInstall(installerTempPath string, translator *Translator) (err error)
 	return errors.New("TUI not implemented")
 }
 
-// startLogging sets up the logging
-func startLogging(logFilename string) *os.File {
-	logfile, err := os.OpenFile(logFilename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.SetFlags(log.Ldate | log.Ltime)
-	// log.SetOutput(io.MultiWriter(os.Stdout, logfile))
-	log.SetOutput(logfile)
-	return logfile
-}
-
 // loadGuiPlugin tries and loads the code from gui.so, casts and returns the constructor
 // and run-function for the GUI. If there are errors, a message is displayed using
 // Zenity and the error is logged and returned.
@@ -247,13 +644,29 @@ func loadGuiPlugin(installerTempPath string, translator *Translator) (
 func handleGuiErr(msg string, errs ...error) (err error) {
 	for _, err = range errs {
 		if err != nil {
-			log.Println("Unable to load GUI:", err)
+			slog.Error("Unable to load GUI", ErrorAttr(err))
 		}
 	}
 	if len(msg) > 0 {
-		log.Println(msg)
+		slog.Error(msg)
 		fmt.Println(msg)
 	}
-	flag.PrintDefaults()
+	printUsage()
 	return
 }
+
+// printUsage prints the commandline usage like the flag package's default, but without
+// the internal -elevated flag, which only the installer itself passes.
+func printUsage() {
+	output := flag.CommandLine.Output()
+	fmt.Fprintf(output, "Usage of %s:\n", flag.CommandLine.Name())
+	public := flag.NewFlagSet(flag.CommandLine.Name(), flag.ContinueOnError)
+	public.SetOutput(output)
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name != elevatedFlag {
+			public.Var(f.Value, f.Name, f.Usage)
+			public.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	public.PrintDefaults()
+}
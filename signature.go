@@ -0,0 +1,350 @@
+package linux_installer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/GeertJohan/go.rice"
+)
+
+const (
+	// payloadManifestFilename and payloadSignatureFilename are the names of the signed
+	// payload manifest and its signature, inside the resources box. Both are excluded
+	// from the manifest itself.
+	payloadManifestFilename  = "payload-manifest.json"
+	payloadSignatureFilename = "payload-manifest.sig"
+)
+
+// payloadBoxNames are the boxes covered by the payload manifest, in the order they
+// appear in the manifest. They have to match the boxes in openBoxes().
+var payloadBoxNames = []string{"resources", "data-compressed"}
+
+// payloadPublicKeyPlaceholder is the value of payloadPublicKey in the naked installer.
+// It has the length of a base64-encoded Ed25519 public key, so that EmbedPublicKey()
+// can overwrite it in the binary with the key of each product.
+const payloadPublicKeyPlaceholder = "@@linux_installer.payloadPublicKey@@@@@@@@@@"
+
+// payloadPublicKey is the base64-encoded Ed25519 public key that the payload signature
+// is checked against. It is written into a copy of the naked installer binary by
+// EmbedPublicKey() when the payload is signed, or compiled in with
+//
+//	go build -ldflags "-X github.com/grandchild/linux_installer.payloadPublicKey=..."
+//
+// If it is empty or still the placeholder, the installer is unsigned and doesn't verify
+// its payload.
+var payloadPublicKey = payloadPublicKeyPlaceholder
+
+var (
+	payloadVerifyOnce sync.Once
+	payloadVerifyErr  error
+)
+
+type (
+	// PayloadManifest lists the SHA-256 hashes of all files in the payload boxes, and a
+	// digest of each box. It is created and signed when the installer is built, and
+	// verified by the installer before anything is unpacked.
+	PayloadManifest struct {
+		Boxes []PayloadBox `json:"boxes"`
+	}
+	// PayloadBox is the part of a PayloadManifest for a single box. Files maps the path
+	// of each file in the box to its SHA-256 hash. SHA256 is the hash of all files'
+	// paths and hashes, in the format of the "sha256sum" tool, sorted by path.
+	PayloadBox struct {
+		Name   string            `json:"name"`
+		SHA256 string            `json:"sha256"`
+		Files  map[string]string `json:"files"`
+	}
+)
+
+// GenerateSigningKey creates a new Ed25519 key pair, writes the private key into
+// keyFile and returns the public key. Both keys are base64-encoded.
+func GenerateSigningKey(keyFile string) (publicKey string, err error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	encoded := base64.StdEncoding.EncodeToString(private.Seed())
+	err = ioutil.WriteFile(keyFile, []byte(encoded+"\n"), 0600)
+	return SigningPublicKey(private), err
+}
+
+// LoadSigningKey reads a private key written by GenerateSigningKey().
+func LoadSigningKey(keyFile string) (ed25519.PrivateKey, error) {
+	content, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s is not a valid signing key", keyFile)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// SigningPublicKey returns the base64-encoded public key of a private key.
+func SigningPublicKey(privateKey ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(privateKey.Public().(ed25519.PublicKey))
+}
+
+// SignPayload creates the payload manifest for the box directories inside dir, and
+// signs it with the private key. The manifest and signature are written into the
+// resources directory, so they are appended to the installer along with it.
+func SignPayload(dir string, privateKey ed25519.PrivateKey) error {
+	manifest := &PayloadManifest{}
+	for _, name := range payloadBoxNames {
+		box, err := hashPayloadDir(name, filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		manifest.Boxes = append(manifest.Boxes, box)
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	signature := ed25519.Sign(privateKey, content)
+	resourceDir := filepath.Join(dir, payloadBoxNames[0])
+	err = ioutil.WriteFile(filepath.Join(resourceDir, payloadManifestFilename), content, 0644)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(
+		filepath.Join(resourceDir, payloadSignatureFilename),
+		[]byte(base64.StdEncoding.EncodeToString(signature)+"\n"),
+		0644,
+	)
+}
+
+// EmbedPublicKey writes the base64-encoded public key over the placeholder in the
+// installer binary, so that it only accepts payloads signed with the matching private
+// key. The binary has to be a fresh copy of the naked installer.
+func EmbedPublicKey(installerFile string, publicKey string) error {
+	if len(publicKey) != len(payloadPublicKeyPlaceholder) {
+		return fmt.Errorf("%s is not a valid public key", publicKey)
+	}
+	info, err := os.Stat(installerFile)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(installerFile)
+	if err != nil {
+		return err
+	}
+	placeholder := []byte(payloadPublicKeyPlaceholder)
+	if !bytes.Contains(content, placeholder) {
+		return fmt.Errorf(
+			"%s is not a naked installer, or already has a public key", installerFile,
+		)
+	}
+	content = bytes.ReplaceAll(content, placeholder, []byte(publicKey))
+	return ioutil.WriteFile(installerFile, content, info.Mode())
+}
+
+// PayloadSigned returns whether the installer has a public key, and thus verifies its
+// payload. The placeholder is recognized by its first character, which can't appear in
+// base64. Comparing against the placeholder string itself doesn't work, since the
+// linker stores equal strings only once, and EmbedPublicKey() would overwrite both.
+func PayloadSigned() bool {
+	return len(payloadPublicKey) > 0 && payloadPublicKey[0] != '@'
+}
+
+// VerifyPayload checks the signature of the payload manifest against the compiled-in
+// public key, and the contents of all payload boxes against the manifest. The payload
+// is only checked once, later calls return the first result.
+//
+// The returned error is ErrPayloadSignature, wrapping the reason.
+func VerifyPayload() error {
+	payloadVerifyOnce.Do(func() {
+		payloadVerifyErr = verifyPayload()
+		if payloadVerifyErr != nil {
+			slog.Error("Payload verification failed", ErrorAttr(payloadVerifyErr))
+			payloadVerifyErr = ErrPayloadSignature.wrap(payloadVerifyErr)
+		} else {
+			slog.Info("Payload verified")
+		}
+	})
+	return payloadVerifyErr
+}
+
+// verifyPayload does the actual work for VerifyPayload().
+func verifyPayload() error {
+	if !PayloadSigned() {
+		return errors.New("the installer has no public key to verify its payload")
+	}
+	content, err := resourcesBox.Bytes(payloadManifestFilename)
+	if err != nil {
+		return errors.New("the payload is not signed")
+	}
+	signature, err := resourcesBox.String(payloadSignatureFilename)
+	if err != nil {
+		return errors.New("the payload is not signed")
+	}
+	manifest, err := verifyManifest(payloadPublicKey, content, signature)
+	if err != nil {
+		return err
+	}
+	boxes := make(map[string]func() (PayloadBox, error))
+	for name, box := range map[string]*rice.Box{
+		payloadBoxNames[0]: resourcesBox,
+		payloadBoxNames[1]: dataBox,
+	} {
+		name, box := name, box
+		boxes[name] = func() (PayloadBox, error) {
+			return hashPayloadBox(
+				name,
+				func(walkFn filepath.WalkFunc) error { return box.Walk("", walkFn) },
+				func(path string) (io.ReadCloser, error) { return box.Open(path) },
+			)
+		}
+	}
+	return manifest.verify(boxes)
+}
+
+// verifyManifest checks the base64-encoded signature of the payload manifest content
+// against the base64-encoded public key, and returns the manifest.
+func verifyManifest(
+	publicKeyText string, content []byte, signatureText string,
+) (*PayloadManifest, error) {
+	publicKey, err := base64.StdEncoding.DecodeString(publicKeyText)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("the installer's public key is invalid")
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signatureText))
+	if err != nil || !ed25519.Verify(publicKey, content, signature) {
+		return nil, errors.New("the payload signature is invalid")
+	}
+	manifest := &PayloadManifest{}
+	err = json.Unmarshal(content, manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// verify checks the payload boxes against the manifest. boxes maps the name of each box
+// to a function which hashes its files, see hashPayloadBox().
+func (m *PayloadManifest) verify(boxes map[string]func() (PayloadBox, error)) error {
+	if len(m.Boxes) != len(boxes) {
+		return errors.New("the payload manifest doesn't list all boxes")
+	}
+	for _, expected := range m.Boxes {
+		hash := boxes[expected.Name]
+		if hash == nil {
+			return fmt.Errorf("the payload manifest lists unknown box %s", expected.Name)
+		}
+		actual, err := hash()
+		if err != nil {
+			return err
+		}
+		if actual.SHA256 != expected.SHA256 {
+			return expected.diff(actual)
+		}
+	}
+	return nil
+}
+
+// hashPayloadDir hashes all files in the directory of a box, see hashPayloadBox().
+func hashPayloadDir(name string, dir string) (PayloadBox, error) {
+	walk := func(walkFn filepath.WalkFunc) error {
+		return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			relPath, _ := filepath.Rel(dir, path)
+			return walkFn(relPath, info, err)
+		})
+	}
+	open := func(path string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(dir, path))
+	}
+	return hashPayloadBox(name, walk, open)
+}
+
+// hashPayloadBox hashes all files in a box. walk walks the box, passing paths relative
+// to the box, and open opens a file by such a path. The manifest and its signature are
+// skipped.
+func hashPayloadBox(
+	name string,
+	walk func(filepath.WalkFunc) error,
+	open func(string) (io.ReadCloser, error),
+) (PayloadBox, error) {
+	box := PayloadBox{Name: name, Files: make(map[string]string)}
+	err := walk(func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		path = filepath.ToSlash(path)
+		if path == payloadManifestFilename || path == payloadSignatureFilename {
+			return nil
+		}
+		file, err := open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		hash := sha256.New()
+		_, err = io.Copy(hash, file)
+		if err != nil {
+			return err
+		}
+		box.Files[path] = hex.EncodeToString(hash.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return box, err
+	}
+	paths := make([]string, 0, len(box.Files))
+	for path := range box.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	digest := sha256.New()
+	for _, path := range paths {
+		fmt.Fprintf(digest, "%s  %s\n", box.Files[path], path)
+	}
+	box.SHA256 = hex.EncodeToString(digest.Sum(nil))
+	return box, nil
+}
+
+// diff returns an error naming the first file in which the actual box differs from the
+// expected one.
+func (expected *PayloadBox) diff(actual PayloadBox) error {
+	paths := make([]string, 0, len(expected.Files)+len(actual.Files))
+	for path := range expected.Files {
+		paths = append(paths, path)
+	}
+	for path := range actual.Files {
+		if _, ok := expected.Files[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		expectedHash, inExpected := expected.Files[path]
+		actualHash, inActual := actual.Files[path]
+		switch {
+		case !inActual:
+			return fmt.Errorf("%s/%s is missing", expected.Name, path)
+		case !inExpected:
+			return fmt.Errorf("%s/%s is not part of the signed payload", expected.Name, path)
+		case expectedHash != actualHash:
+			return fmt.Errorf("%s/%s is damaged or was modified", expected.Name, path)
+		}
+	}
+	return fmt.Errorf("box %s does not match the signed payload", expected.Name)
+}
@@ -0,0 +1,217 @@
+package linux_installer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePayloadDirs creates the box directories of a small payload inside dir.
+func writePayloadDirs(t *testing.T, dir string) {
+	t.Helper()
+	files := map[string]string{
+		"resources/config.yml":                "variables: {}\n",
+		"resources/language/en.yml":           "title: Example\n",
+		"data-compressed/data.zip":            "not really a zip",
+		"data-compressed/components/docs.zip": "neither is this",
+	}
+	for path, content := range files {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// verifyPayloadDirs verifies the payload signed inside dir like verifyPayload() does
+// for the boxes appended to the installer.
+func verifyPayloadDirs(dir string, publicKey string) error {
+	resourceDir := filepath.Join(dir, payloadBoxNames[0])
+	content, err := os.ReadFile(filepath.Join(resourceDir, payloadManifestFilename))
+	if err != nil {
+		return err
+	}
+	signature, err := os.ReadFile(filepath.Join(resourceDir, payloadSignatureFilename))
+	if err != nil {
+		return err
+	}
+	manifest, err := verifyManifest(publicKey, content, string(signature))
+	if err != nil {
+		return err
+	}
+	boxes := make(map[string]func() (PayloadBox, error))
+	for _, name := range payloadBoxNames {
+		name := name
+		boxes[name] = func() (PayloadBox, error) {
+			return hashPayloadDir(name, filepath.Join(dir, name))
+		}
+	}
+	return manifest.verify(boxes)
+}
+
+func TestSignPayload(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "payload.key")
+	publicKey, err := GenerateSigningKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := LoadSigningKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := SigningPublicKey(privateKey); got != publicKey {
+		t.Fatalf("public key of loaded key = %s, want %s", got, publicKey)
+	}
+	otherKeyFile := filepath.Join(t.TempDir(), "other.key")
+	otherPublicKey, err := GenerateSigningKey(otherKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		modify    func(dir string) error
+		publicKey string
+		wantErr   string
+	}{
+		{"unmodified", nil, publicKey, ""},
+		{
+			"modified file",
+			func(dir string) error {
+				return os.WriteFile(
+					filepath.Join(dir, "data-compressed", "data.zip"), []byte("evil"), 0644,
+				)
+			},
+			publicKey, "data-compressed/data.zip is damaged or was modified",
+		},
+		{
+			"missing file",
+			func(dir string) error {
+				return os.Remove(filepath.Join(dir, "resources", "language", "en.yml"))
+			},
+			publicKey, "resources/language/en.yml is missing",
+		},
+		{
+			"added file",
+			func(dir string) error {
+				return os.WriteFile(
+					filepath.Join(dir, "resources", "extra.sh"), []byte("evil"), 0755,
+				)
+			},
+			publicKey, "resources/extra.sh is not part of the signed payload",
+		},
+		{
+			"modified manifest",
+			func(dir string) error {
+				path := filepath.Join(dir, "resources", payloadManifestFilename)
+				content, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				return os.WriteFile(path, append(content, '\n'), 0644)
+			},
+			publicKey, "the payload signature is invalid",
+		},
+		{"other key", nil, otherPublicKey, "the payload signature is invalid"},
+		{"invalid key", nil, "not a key", "the installer's public key is invalid"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writePayloadDirs(t, dir)
+			if err := SignPayload(dir, privateKey); err != nil {
+				t.Fatal(err)
+			}
+			if test.modify != nil {
+				if err := test.modify(dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := verifyPayloadDirs(dir, test.publicKey)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("verification failed: %v", err)
+			case test.wantErr != "" && err == nil:
+				t.Errorf("verification succeeded, want error %q", test.wantErr)
+			case test.wantErr != "" && !strings.Contains(err.Error(), test.wantErr):
+				t.Errorf("error = %q, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestManifestVerifyBoxes(t *testing.T) {
+	box := PayloadBox{Name: "resources", SHA256: "abc", Files: map[string]string{}}
+	manifest := &PayloadManifest{Boxes: []PayloadBox{box}}
+	hash := func() (PayloadBox, error) { return box, nil }
+
+	err := manifest.verify(map[string]func() (PayloadBox, error){
+		"resources": hash, "data-compressed": hash,
+	})
+	if err == nil || !strings.Contains(err.Error(), "doesn't list all boxes") {
+		t.Errorf("error with a missing box = %v", err)
+	}
+	err = manifest.verify(map[string]func() (PayloadBox, error){"other": hash})
+	if err == nil || !strings.Contains(err.Error(), "unknown box resources") {
+		t.Errorf("error with an unknown box = %v", err)
+	}
+}
+
+func TestLoadSigningKeyInvalid(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "payload.key")
+	if err := os.WriteFile(keyFile, []byte("c2hvcnQ=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSigningKey(keyFile); err == nil {
+		t.Error("loaded a key that is too short")
+	}
+	if _, err := LoadSigningKey(filepath.Join(t.TempDir(), "missing.key")); err == nil {
+		t.Error("loaded a missing key file")
+	}
+}
+
+func TestEmbedPublicKey(t *testing.T) {
+	privateKey, err := LoadSigningKey(writeSigningKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := SigningPublicKey(privateKey)
+	installer := filepath.Join(t.TempDir(), "Setup")
+	content := "\x7fELF..." + payloadPublicKeyPlaceholder + "..."
+	if err := os.WriteFile(installer, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmbedPublicKey(installer, publicKey); err != nil {
+		t.Fatal(err)
+	}
+	embedded, err := os.ReadFile(installer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x7fELF..." + publicKey + "..."; string(embedded) != want {
+		t.Errorf("installer = %q, want %q", embedded, want)
+	}
+	if info, err := os.Stat(installer); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("installer lost its mode: %v, %v", info.Mode(), err)
+	}
+	if err := EmbedPublicKey(installer, publicKey); err == nil {
+		t.Error("embedded a second public key")
+	}
+	if err := EmbedPublicKey(installer, "c2hvcnQ="); err == nil {
+		t.Error("embedded an invalid public key")
+	}
+}
+
+// writeSigningKey creates a new signing key, and returns its file name.
+func writeSigningKey(t *testing.T) string {
+	t.Helper()
+	keyFile := filepath.Join(t.TempDir(), "payload.key")
+	if _, err := GenerateSigningKey(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}
@@ -0,0 +1,367 @@
+package linux_installer
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// stagingDirSuffix is appended to the hidden sibling directory of the target, into
+	// which a staged installation is written.
+	stagingDirSuffix = ".installing"
+	// previousDirSuffix is appended to the hidden sibling directory of the target, into
+	// which a previous installation is moved when the staging directory replaces it.
+	previousDirSuffix = ".previous"
+)
+
+// siblingDir returns a hidden directory next to the target, with the given suffix.
+func (i *Installer) siblingDir(suffix string) string {
+	return filepath.Join(filepath.Dir(i.Target), "."+filepath.Base(i.Target)+suffix)
+}
+
+// installDir returns the directory the files are currently installed into. This is the
+// staging directory during a staged installation, and the target otherwise.
+func (i *Installer) installDir() string {
+	if len(i.staging) > 0 {
+		return i.staging
+	}
+	return i.Target
+}
+
+// canStage returns whether the installation can be staged. The target must either not
+// exist yet, be empty, or be the previous installation that is being updated. A staged
+// installation into a directory with other contents would have to carry all of them
+// over into the staging directory. The target also can't be a mount point, because the
+// staging directory has to be on the same filesystem.
+func (i *Installer) canStage(updating bool) bool {
+	entries, err := os.ReadDir(i.Target)
+	if os.IsNotExist(err) {
+		return true
+	}
+	if err != nil || !osSameFilesystem(i.Target, filepath.Dir(i.Target)) { // os-specific
+		return false
+	}
+	return updating || len(entries) == 0
+}
+
+// startStaging creates the staging directory, after removing any leftovers of an
+// earlier installation that was interrupted.
+func (i *Installer) startStaging() error {
+	staging := i.siblingDir(stagingDirSuffix)
+	for _, leftover := range []string{staging, i.siblingDir(previousDirSuffix)} {
+		if _, err := os.Lstat(leftover); err == nil {
+			slog.Info("Removing leftover directory", "path", leftover)
+			err = os.RemoveAll(leftover)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err := os.MkdirAll(staging, 0755)
+	if err != nil {
+		return err
+	}
+	slog.Info("Staging installation", "path", staging)
+	i.staging = staging
+	return nil
+}
+
+// carryOverUserFiles hard-links all files from the previous installation into the
+// staging directory which are not part of the previous installation, i.e. files created
+// by the user. Directories are recreated and symlinks copied. Files which the payload
+// replaces are linked into the backup directory of the staging directory instead, so
+// that they are backed up like in an installation that isn't staged (see
+// backupFile()). Files which are already in the staging directory, because a resumed
+// installation carried them over before, are skipped.
+func (i *Installer) carryOverUserFiles(previousFiles map[string]InstallRecordFile) error {
+	current := make(map[string]bool, len(i.files))
+	for _, file := range i.files {
+		current[file.Target] = true
+	}
+	return filepath.Walk(i.Target, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(i.Target, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+		if relPath == backupDirName {
+			return filepath.SkipDir
+		} else if relPath == journalFilename {
+			return nil
+		}
+		name := filepath.ToSlash(relPath)
+		if info.IsDir() {
+			name += "/" // like directory entries in the zip
+		}
+		if _, ok := previousFiles[name]; ok || (current[name] && info.IsDir()) {
+			return nil
+		}
+		stagedPath := filepath.Join(i.staging, relPath)
+		if current[name] {
+			stagedPath = filepath.Join(i.staging, backupDirName, relPath)
+		}
+		if _, err := os.Lstat(stagedPath); err == nil {
+			return nil
+		}
+		err = os.MkdirAll(filepath.Dir(stagedPath), 0755)
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			err = os.Mkdir(stagedPath, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			var linkTarget string
+			linkTarget, err = os.Readlink(path)
+			if err == nil {
+				err = os.Symlink(linkTarget, stagedPath)
+			}
+		default:
+			err = os.Link(path, stagedPath)
+		}
+		if err == nil {
+			slog.Debug("Kept user file", "file", path)
+		}
+		return err
+	})
+}
+
+// linkUnchanged hard-links an unchanged file of the previous installation into the
+// staging directory, instead of extracting it again. Returns whether that worked.
+func (i *Installer) linkUnchanged(file *InstallFile) bool {
+	stagedPath := i.fileTarget(file)
+	err := os.MkdirAll(filepath.Dir(stagedPath), 0755)
+	if err == nil {
+		err = os.Link(filepath.Join(i.Target, file.Target), stagedPath)
+	}
+	return err == nil
+}
+
+// commitStaging moves the staging directory into place as the target. An existing
+// target is atomically exchanged with the staging directory if possible. The replaced
+// installation is kept next to the target until PostInstall() succeeded (see
+// discardReplaced()), so that a rollback can still move it back into place (see
+// restoreReplaced()). The journal moves along into the target, and has to be removed
+// only after this returned, so that an interrupted commit can be rolled back (see
+// cleanUpStaging()).
+func (i *Installer) commitStaging() error {
+	staging := i.staging
+	replaced := ""
+	if _, err := os.Lstat(i.Target); os.IsNotExist(err) {
+		err = os.Rename(staging, i.Target)
+		if err != nil {
+			return err
+		}
+	} else {
+		replaced = i.siblingDir(previousDirSuffix)
+		err = osExchangeDirs(staging, i.Target) // os-specific
+		if err == nil {
+			// Keep the replaced installation where restorePrevious() finds it.
+			if os.Rename(staging, replaced) != nil {
+				replaced = staging
+			}
+		} else {
+			slog.Warn("Unable to exchange directories atomically", ErrorAttr(err))
+			err = i.replaceTarget()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err := syncDir(filepath.Dir(i.Target))
+	if err != nil {
+		slog.Warn(
+			"Unable to sync directory", "path", filepath.Dir(i.Target), ErrorAttr(err),
+		)
+	}
+	slog.Info("Moved staged installation", "path", i.Target)
+	i.staging = ""
+	i.committed = true
+	i.replaced = replaced
+	return nil
+}
+
+// discardReplaced removes the installation that commitStaging() replaced, once it is
+// no longer needed, i.e. after PostInstall() succeeded.
+func (i *Installer) discardReplaced() {
+	if len(i.replaced) > 0 {
+		err := os.RemoveAll(i.replaced)
+		if err != nil {
+			slog.Warn(
+				"Unable to remove previous installation", "path", i.replaced,
+				ErrorAttr(err),
+			)
+		} else {
+			slog.Info("Removed previous installation", "path", i.replaced)
+		}
+	}
+	i.committed = false
+	i.replaced = ""
+}
+
+// restoreReplaced rolls back a committed staged installation. The installation that
+// commitStaging() replaced is moved back into place, atomically if possible, and the
+// new installation is removed. If there was none, the new installation is removed
+// entirely, since the target didn't exist before. If the previous installation can't
+// be moved back, it is restored by the next installation, see restorePrevious().
+func (i *Installer) restoreReplaced() error {
+	discarded := i.siblingDir(stagingDirSuffix)
+	if i.replaced == discarded {
+		discarded = i.siblingDir(previousDirSuffix)
+	}
+	if len(i.replaced) > 0 && osExchangeDirs(i.replaced, i.Target) == nil {
+		discarded = i.replaced
+	} else {
+		err := os.Rename(i.Target, discarded)
+		if err != nil {
+			return err
+		}
+		if len(i.replaced) > 0 {
+			err = os.Rename(i.replaced, i.Target)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err := syncDir(filepath.Dir(i.Target))
+	if err != nil {
+		slog.Warn(
+			"Unable to sync directory", "path", filepath.Dir(i.Target), ErrorAttr(err),
+		)
+	}
+	i.committed = false
+	i.replaced = ""
+	return os.RemoveAll(discarded)
+}
+
+// replaceTarget replaces the target with the staging directory, if the two can't be
+// exchanged atomically. The target is first moved aside next to it, and restored if the
+// staging directory can't be moved. If even that fails, it is restored by the next
+// installation, see restorePrevious().
+func (i *Installer) replaceTarget() error {
+	previous := i.siblingDir(previousDirSuffix)
+	err := os.Rename(i.Target, previous)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(i.staging, i.Target)
+	if err != nil {
+		if restoreErr := os.Rename(previous, i.Target); restoreErr != nil {
+			slog.Error(
+				"Unable to restore previous installation", "path", previous,
+				ErrorAttr(restoreErr),
+			)
+		}
+		return err
+	}
+	return nil
+}
+
+// restorePrevious moves a previous installation that replaceTarget() moved aside back
+// into place, if the target is missing, because the installation that replaced it was
+// interrupted.
+func (i *Installer) restorePrevious() error {
+	previous := i.siblingDir(previousDirSuffix)
+	if _, err := os.Lstat(previous); err != nil {
+		return nil
+	}
+	if _, err := os.Lstat(i.Target); !os.IsNotExist(err) {
+		return nil
+	}
+	err := os.Rename(previous, i.Target)
+	if err != nil {
+		return err
+	}
+	slog.Info("Restored previous installation", "path", i.Target)
+	return syncDir(filepath.Dir(i.Target))
+}
+
+// cleanUpStaging cleans up an unfinished staged installation by removing its staging
+// directory, see cleanUpUnfinished(). If it was interrupted while being committed, its
+// journal is in the target, which is then moved back into the staging directory's
+// place and removed as well, and the previous installation is restored. The previous
+// installation is either in the staging directory, where commitStaging() exchanged it,
+// or where replaceTarget() moved it. The steps are ordered such that cleaning up again
+// after another interruption still finds the previous installation.
+func (i *Installer) cleanUpStaging(journal *InstallJournal) error {
+	previous := i.siblingDir(previousDirSuffix)
+	if journal.committing() {
+		if _, err := os.Lstat(journal.Staging); err == nil {
+			err = os.Rename(journal.Staging, previous)
+			if err != nil {
+				return err
+			}
+		}
+		err := os.Rename(i.Target, journal.Staging)
+		if err != nil {
+			return err
+		}
+	}
+	err := os.RemoveAll(journal.Staging)
+	if err != nil {
+		return err
+	}
+	return i.restorePrevious()
+}
+
+// rollbackCommitted rolls back a staged installation that was already moved into
+// place, see restoreReplaced().
+func (i *Installer) rollbackCommitted() {
+	err := i.restoreReplaced()
+	if err != nil {
+		slog.Warn(
+			"Unable to restore previous installation", "phase", PhaseRolledBack,
+			"path", i.Target, ErrorAttr(err),
+		)
+	} else {
+		slog.Info(
+			"Restored previous installation", "phase", PhaseRolledBack, "path", i.Target,
+		)
+	}
+	i.statusLock.Lock()
+	for _, file := range i.files {
+		file.installed = false
+		file.unchanged = false
+		file.backup = ""
+	}
+	i.installedSize = 0
+	i.statusLock.Unlock()
+	i.removedFiles = nil
+}
+
+// syncDir syncs a directory to disk, which makes renames of its entries durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// discardStaging removes the staging directory, and with it all files installed so
+// far. The target is left untouched.
+func (i *Installer) discardStaging() {
+	err := os.RemoveAll(i.staging)
+	if err != nil {
+		slog.Warn(
+			"Unable to remove staging directory", "phase", PhaseRolledBack,
+			"path", i.staging, ErrorAttr(err),
+		)
+	} else {
+		slog.Info(
+			"Removed staging directory", "phase", PhaseRolledBack, "path", i.staging,
+		)
+	}
+	i.statusLock.Lock()
+	for _, file := range i.files {
+		file.installed = false
+		file.unchanged = false
+	}
+	i.installedSize = 0
+	i.statusLock.Unlock()
+	i.staging = ""
+}
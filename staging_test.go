@@ -0,0 +1,133 @@
+package linux_installer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// stageInstallation stages an installation into target that consists of a single file
+// with the given content, and commits it.
+func stageInstallation(t *testing.T, target string, content string) *Installer {
+	t.Helper()
+	installer := &Installer{Target: target}
+	if err := installer.startStaging(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(installer.staging, "file")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := installer.commitStaging(); err != nil {
+		t.Fatal(err)
+	}
+	return installer
+}
+
+// checkInstalledContent fails the test unless the file in target has the content.
+func checkInstalledContent(t *testing.T, target string, want string) {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(target, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != want {
+		t.Errorf("installed file = %q, want %q", content, want)
+	}
+}
+
+// checkNoSiblings fails the test if the staging or previous directory of the installer
+// are left next to the target.
+func checkNoSiblings(t *testing.T, installer *Installer) {
+	t.Helper()
+	for _, suffix := range []string{stagingDirSuffix, previousDirSuffix} {
+		if _, err := os.Lstat(installer.siblingDir(suffix)); err == nil {
+			t.Errorf("%s was left behind", installer.siblingDir(suffix))
+		}
+	}
+}
+
+func TestCommitStagingKeepsReplaced(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "app")
+	stageInstallation(t, target, "v1").discardReplaced()
+	installer := stageInstallation(t, target, "v2")
+	checkInstalledContent(t, target, "v2")
+	if !installer.committed || len(installer.replaced) == 0 {
+		t.Fatal("the replaced installation wasn't kept")
+	}
+	checkInstalledContent(t, installer.replaced, "v1")
+
+	installer.discardReplaced()
+	checkInstalledContent(t, target, "v2")
+	checkNoSiblings(t, installer)
+}
+
+func TestRestoreReplaced(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "app")
+	stageInstallation(t, target, "v1").discardReplaced()
+	installer := stageInstallation(t, target, "v2")
+	if err := installer.restoreReplaced(); err != nil {
+		t.Fatal(err)
+	}
+	checkInstalledContent(t, target, "v1")
+	checkNoSiblings(t, installer)
+	if installer.committed {
+		t.Error("installer is still committed")
+	}
+}
+
+func TestRestoreReplacedNew(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "app")
+	installer := stageInstallation(t, target, "v1")
+	if err := installer.restoreReplaced(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(target); !os.IsNotExist(err) {
+		t.Errorf("new installation wasn't removed: %v", err)
+	}
+	checkNoSiblings(t, installer)
+}
+
+func TestCarryOverUserFiles(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "app")
+	files := map[string]string{
+		"previous": "previous installation",
+		"user":     "created by the user",
+		"payload":  "replaced by the payload",
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(target, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	installer := &Installer{
+		Target: target,
+		files:  []*InstallFile{{Target: "previous"}, {Target: "payload"}},
+	}
+	if err := installer.startStaging(); err != nil {
+		t.Fatal(err)
+	}
+	previousFiles := map[string]InstallRecordFile{"previous": {Path: "previous"}}
+	if err := installer.carryOverUserFiles(previousFiles); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"user":                     files["user"],
+		"previous":                 "",
+		"payload":                  "",
+		backupDirName + "/payload": files["payload"],
+	} {
+		content, err := os.ReadFile(filepath.Join(installer.staging, path))
+		if len(want) == 0 {
+			if err == nil {
+				t.Errorf("%s was carried over", path)
+			}
+		} else if err != nil || string(content) != want {
+			t.Errorf("%s = %q, %v, want %q", path, content, err, want)
+		}
+	}
+}
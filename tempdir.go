@@ -0,0 +1,107 @@
+package linux_installer
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// tempDirPrefix is the beginning of the name of each installer run's temporary
+	// directory. The rest of the name is random.
+	tempDirPrefix = "linux_installer-"
+	// tempDirLockName is the name of the lock file inside the temporary directory. It is
+	// locked as long as the installer that created the directory runs.
+	tempDirLockName = ".lock"
+	// staleTempDirAge is the age after which a temporary directory without a lock file
+	// is considered stale. Younger ones may belong to an installer that just started.
+	staleTempDirAge = time.Hour
+)
+
+// tempDir is the private temporary directory of an installer run, into which the GUI
+// plugin and the hook scripts are unpacked.
+type tempDir struct {
+	path string
+	lock *os.File
+}
+
+// createTempDir creates the temporary directory of this installer run inside parent, or
+// inside the system's temporary directory (usually $TMPDIR or /tmp) if parent is empty.
+// Stale directories of earlier runs that crashed are removed first.
+//
+// The directory has a random name and is only accessible by the current user, so that
+// installers running at the same time, also of different users, don't interfere, and
+// nobody can prepare its contents beforehand. It stays locked until it is removed, so
+// that it isn't taken for the leftover of a crashed run.
+func createTempDir(parent string) (*tempDir, error) {
+	if len(parent) == 0 {
+		parent = os.TempDir()
+	}
+	err := os.MkdirAll(parent, 0700)
+	if err != nil {
+		return nil, errTempDir.wrap(err)
+	}
+	removeStaleTempDirs(parent)
+	path, err := os.MkdirTemp(parent, tempDirPrefix)
+	if err != nil {
+		return nil, errTempDir.wrap(err)
+	}
+	lock, err := os.OpenFile(
+		filepath.Join(path, tempDirLockName), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600,
+	)
+	if err == nil && !osTryLock(lock) { // os-specific
+		lock.Close()
+		err = os.ErrPermission
+	}
+	if err != nil {
+		os.RemoveAll(path)
+		return nil, errTempDir.wrap(err)
+	}
+	slog.Info("Using temporary directory", "path", path)
+	return &tempDir{path: path, lock: lock}, nil
+}
+
+// remove removes the temporary directory with all its contents, and releases its lock.
+func (d *tempDir) remove() {
+	os.RemoveAll(d.path)
+	d.lock.Close()
+}
+
+// removeStaleTempDirs removes the temporary directories of installer runs that crashed,
+// i.e. whose lock file isn't locked anymore. Directories of other users, and symlinks
+// pretending to be temporary directories, are left alone.
+func removeStaleTempDirs(parent string) {
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), tempDirPrefix) {
+			continue
+		}
+		path := filepath.Join(parent, entry.Name())
+		if !staleTempDir(path) {
+			continue
+		}
+		if os.RemoveAll(path) == nil {
+			slog.Info("Removed stale temporary directory", "path", path)
+		}
+	}
+}
+
+// staleTempDir returns whether the temporary directory at path was left behind by an
+// installer run that crashed. Its lock file must be unlocked, or missing for longer than
+// staleTempDirAge.
+func staleTempDir(path string) bool {
+	lock, err := os.OpenFile(filepath.Join(path, tempDirLockName), os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		info, err := os.Lstat(path)
+		return err == nil && time.Since(info.ModTime()) > staleTempDirAge
+	} else if err != nil {
+		return false
+	}
+	defer lock.Close()
+	return osTryLock(lock)
+}
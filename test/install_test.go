@@ -3,7 +3,7 @@ package main
 import (
 	"testing"
 
-	"github.com/grandchild/linux_installer"
+	installer "github.com/grandchild/linux_installer"
 )
 
 func TestNewInstallerSizeIsZero(t *testing.T) {
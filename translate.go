@@ -3,7 +3,7 @@ package linux_installer
 import (
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"regexp"
 	"sort"
 
@@ -43,7 +43,9 @@ func NewTranslatorVar(variables VariableMap) *Translator {
 		langStrings := make(VariableMap)
 		err := yaml.Unmarshal([]byte(content), langStrings)
 		if err != nil {
-			log.Printf("Unable to parse language file %s\n", filename)
+			slog.Error(
+				"Unable to parse language file", "path", filename, ErrorAttr(err),
+			)
 			continue
 		}
 		languages[languageTag] = langStrings
@@ -154,6 +156,13 @@ func (t *Translator) getLocale() string {
 	return match.String()
 }
 
+// GetWith returns the localized string for a given string key like Get, with additional
+// variables for the template references in it, e.g. values for an error message.
+func (t *Translator) GetWith(key string, variables VariableMap) string {
+	str := t.getRaw(key, t.language)
+	return t.expand(str, t.language, variables)
+}
+
 // Expand expands template variables in the given str (if any) with the translator's
 // current language's strings.
 func (t *Translator) Expand(str string) (expanded string) {
@@ -161,9 +170,11 @@ func (t *Translator) Expand(str string) (expanded string) {
 }
 
 // expand expands template variables in the given str (if any) with the translator's
-// strings for the given language. If the language is not available in the translator,
-// then an empty string is returned.
-func (t *Translator) expand(str, language string) (expanded string) {
+// strings for the given language, and any additional variables. If the language is not
+// available in the translator, then an empty string is returned.
+func (t *Translator) expand(
+	str, language string, variables ...VariableMap,
+) (expanded string) {
 	availableLanguage := language
 	if _, ok := t.langStrings[language]; !ok {
 		if _, ok := t.langStrings[DefaultLanguage]; !ok {
@@ -171,9 +182,10 @@ func (t *Translator) expand(str, language string) (expanded string) {
 		}
 		availableLanguage = DefaultLanguage
 	}
-	return ExpandVariables(
-		str, MergeVariables(t.Variables, t.langStrings[availableLanguage]),
+	variables = append(
+		[]VariableMap{t.Variables, t.langStrings[availableLanguage]}, variables...,
 	)
+	return ExpandVariables(str, MergeVariables(variables...))
 }
 
 // getRaw returns a localized string for a given string key in a given language, without
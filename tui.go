@@ -7,7 +7,7 @@ package linux_installer
 import (
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	// "os"
 	// "path/filepath"
 	// "reflect"
@@ -88,7 +88,7 @@ func NewTui(installerTempPath string, translator Translator) (Tui, error) {
 	builder, errs := TuiBuilderNew(MustGetResource("tui/tui.yml"))
 	if len(errs) > 0 {
 		for _, err := range errs {
-			log.Printf("TUI parse error: %s", err)
+			slog.Error("Unable to parse TUI definition", ErrorAttr(err))
 		}
 		return Tui{}, errors.New("Unable to load tui definition")
 	}
@@ -0,0 +1,403 @@
+package linux_installer
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// uninstallManifestFilename is the name of the manifest inside the installation
+// directory. The installer binary runs in uninstall mode if it finds this file next to
+// itself.
+const uninstallManifestFilename = ".uninstall.json"
+
+// zipLocalHeaderSignature starts each file's local header in a zip archive.
+const zipLocalHeaderSignature = "PK\x03\x04"
+
+type (
+	// UninstallManifest lists all files of an installation, so that the uninstaller can
+	// remove them again. It is written into the installation directory next to the
+	// uninstaller, which is a copy of the installer binary without the data box.
+	//
+	// Language is the language chosen during installation, which the uninstaller uses
+	// as well.
+	UninstallManifest struct {
+		Product     string         `json:"product"`
+		Version     string         `json:"version"`
+		Language    string         `json:"language"`
+		InstallType string         `json:"install_type,omitempty"`
+		Target      string         `json:"target"`
+		Installed   time.Time      `json:"installed"`
+		Files       []ManifestFile `json:"files"`
+	}
+	// ManifestFile is a single installed file or directory. Files have the size and
+	// SHA-256 hash they were installed with, to detect later modifications. Symlinks
+	// have their link target instead of a hash. Path is absolute, since launcher entries
+	// are part of the manifest as well.
+	ManifestFile struct {
+		Path   string `json:"path"`
+		Dir    bool   `json:"dir,omitempty"`
+		Link   string `json:"link,omitempty"`
+		Size   int64  `json:"size"`
+		SHA256 string `json:"sha256,omitempty"`
+	}
+)
+
+// createUninstaller copies the installer binary into the installation directory as the
+// uninstaller, and writes the manifest of all installed files next to it. extraFiles
+// are files outside of the installation, e.g. launcher entries. Returns the path of the
+// uninstaller.
+func (i *Installer) createUninstaller(
+	extraFiles []string, variables VariableMap,
+) (string, error) {
+	manifest := &UninstallManifest{
+		Product:     i.config.Variables["product"],
+		Version:     i.config.Variables["version"],
+		Language:    i.Language,
+		InstallType: i.installTypeId(),
+		Target:      i.Target,
+		Installed:   time.Now(),
+		Files:       make([]ManifestFile, 0, len(i.files)+len(extraFiles)),
+	}
+	for _, file := range i.files {
+		if !file.installed && !file.unchanged {
+			continue
+		}
+		manifestFile := ManifestFile{
+			Path: i.fileTarget(file),
+			Dir:  file.IsDir(),
+			Link: file.link,
+			Size: int64(file.UncompressedSize64),
+		}
+		if !manifestFile.Dir && len(manifestFile.Link) == 0 {
+			manifestFile.SHA256 = file.sha256
+			if len(manifestFile.SHA256) == 0 {
+				manifestFile.SHA256, _ = fileSha256(manifestFile.Path)
+			}
+		}
+		manifest.Files = append(manifest.Files, manifestFile)
+	}
+	for _, path := range extraFiles {
+		manifestFile := ManifestFile{Path: path}
+		if info, err := os.Stat(path); err == nil {
+			manifestFile.Size = info.Size()
+		}
+		manifestFile.SHA256, _ = fileSha256(path)
+		manifest.Files = append(manifest.Files, manifestFile)
+	}
+	uninstallerPath := filepath.Join(i.Target, variables["uninstaller_name"])
+	err := copyExecutable(uninstallerPath)
+	if err != nil {
+		return uninstallerPath, err
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return uninstallerPath, err
+	}
+	return uninstallerPath, ioutil.WriteFile(
+		filepath.Join(i.Target, uninstallManifestFilename), content, 0644,
+	)
+}
+
+// copyExecutable copies the running executable to the given path, without the data box
+// (see copyWithoutData()). The copy is written next to the path first, and then
+// renamed, so that a running uninstaller at that path is not disturbed.
+func copyExecutable(to string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	source, err := os.Open(executable)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	target, err := ioutil.TempFile(filepath.Dir(to), "."+filepath.Base(to))
+	if err != nil {
+		return err
+	}
+	err = copyWithoutData(target, source, info.Size())
+	target.Close()
+	if err == nil {
+		err = os.Chmod(target.Name(), 0755)
+	}
+	if err == nil {
+		err = os.Rename(target.Name(), to)
+	}
+	if err != nil {
+		os.Remove(target.Name())
+	}
+	return err
+}
+
+// copyWithoutData copies an executable with the boxes that "rice append" appended to it
+// as a zip archive, but only keeps the resources box, which the uninstaller needs for
+// its config, translations and hooks. The payload in the data box would only make the
+// uninstaller as large as the installer. An executable without appended boxes, e.g.
+// during development, is copied as it is.
+func copyWithoutData(to io.Writer, executable *os.File, size int64) error {
+	start := int64(-1)
+	appended, err := zip.NewReader(executable, size)
+	if err == nil {
+		start = appendedStart(executable, appended)
+	}
+	if start < 0 {
+		_, err = io.Copy(to, executable)
+		return err
+	}
+	_, err = io.Copy(to, io.NewSectionReader(executable, 0, start))
+	if err != nil {
+		return err
+	}
+	// like "rice append", with offsets from the start of the executable
+	writer := zip.NewWriter(to)
+	writer.SetOffset(start)
+	for _, file := range appended.File {
+		box := strings.SplitN(strings.TrimLeft(file.Name, "/"), "/", 2)[0]
+		if box != "resources" {
+			continue
+		}
+		content, err := file.OpenRaw()
+		if err != nil {
+			return err
+		}
+		fileWriter, err := writer.CreateRaw(&file.FileHeader)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fileWriter, content)
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// appendedStart returns the offset of the zip archive appended to an executable, i.e.
+// of the first local file header, or -1 if it can't be determined. The local headers of
+// archives written by Go have the same name and extra fields as the central directory.
+func appendedStart(executable io.ReaderAt, appended *zip.Reader) int64 {
+	start := int64(-1)
+	for _, file := range appended.File {
+		offset, err := file.DataOffset()
+		if err != nil {
+			return -1
+		}
+		offset -= int64(30 + len(file.Name) + len(file.Extra)) // fixed header is 30 B
+		if start < 0 || offset < start {
+			start = offset
+		}
+	}
+	signature := make([]byte, len(zipLocalHeaderSignature))
+	if start <= 0 {
+		return -1
+	} else if _, err := executable.ReadAt(signature, start); err != nil ||
+		string(signature) != zipLocalHeaderSignature {
+		return -1
+	}
+	return start
+}
+
+// fileSha256 returns the hex-encoded SHA-256 hash of a file's contents.
+func fileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// uninstallManifestPath returns the path of the uninstall manifest next to the running
+// executable, or an empty string if there is none, i.e. if not running as an
+// uninstaller.
+func uninstallManifestPath() string {
+	executable, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	manifestPath := filepath.Join(filepath.Dir(executable), uninstallManifestFilename)
+	if info, err := os.Stat(manifestPath); err != nil || info.IsDir() {
+		return ""
+	}
+	return manifestPath
+}
+
+// loadUninstallManifest reads an uninstall manifest file.
+func loadUninstallManifest(path string) (*UninstallManifest, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &UninstallManifest{}
+	err = json.Unmarshal(content, manifest)
+	return manifest, err
+}
+
+// modifiedFiles returns all files which still exist, but whose size or contents have
+// changed since the installation. Symlinks are modified if they point somewhere else,
+// or were replaced by something other than a symlink.
+func (m *UninstallManifest) modifiedFiles() map[string]bool {
+	modified := make(map[string]bool)
+	for _, file := range m.Files {
+		if file.Dir || (len(file.SHA256) == 0 && len(file.Link) == 0) {
+			continue
+		}
+		info, err := os.Lstat(file.Path)
+		if err != nil {
+			continue
+		}
+		if len(file.Link) > 0 {
+			linkTarget, err := os.Readlink(file.Path)
+			if err != nil || linkTarget != file.Link {
+				modified[file.Path] = true
+			}
+		} else if !info.Mode().IsRegular() || info.Size() != file.Size {
+			modified[file.Path] = true
+		} else if hash, err := fileSha256(file.Path); err != nil || hash != file.SHA256 {
+			modified[file.Path] = true
+		}
+	}
+	return modified
+}
+
+// removeFiles removes all files and directories in the manifest, in reverse order so
+// that directory contents are removed before their directories. Modified files, and
+// directories which aren't empty, are kept. Returns the number of files and empty
+// directories which couldn't be removed.
+func (m *UninstallManifest) removeFiles(modified map[string]bool) (failed int) {
+	for f := len(m.Files) - 1; f >= 0; f-- {
+		path := m.Files[f].Path
+		if modified[path] {
+			slog.Info("Keeping modified file", "file", path)
+			continue
+		}
+		err := os.Remove(path)
+		if err == nil {
+			slog.Debug("Removed file", "file", path)
+		} else if os.IsNotExist(err) {
+			continue
+		} else if entries, _ := os.ReadDir(path); m.Files[f].Dir && len(entries) > 0 {
+			slog.Info("Keeping directory which isn't empty", "path", path)
+		} else {
+			slog.Warn("Unable to remove file", "file", path, ErrorAttr(err))
+			failed++
+		}
+	}
+	return failed
+}
+
+// confirmUninstall asks whether to uninstall, and returns whether the answer was yes. If
+// stdin isn't a terminal, e.g. when run from a script without -yes, or is closed before
+// an answer was given, nobody confirmed the question, so the answer is no.
+func confirmUninstall(translator *Translator) bool {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		slog.Error("Not uninstalling without confirmation, stdin is not a terminal")
+		return false
+	}
+	fmt.Print(translator.Get("uninstall_question") + " ")
+	choice, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Println()
+		return false
+	}
+	return !strings.HasPrefix(strings.TrimSpace(choice), "n")
+}
+
+// RunUninstall runs the uninstaller for the installation described by the manifest at
+// manifestPath. It asks for confirmation, unless yes is set (see confirmUninstall()),
+// and warns about files which were modified since the installation. Those files are
+// kept.
+//
+// The pre-uninstall and post-uninstall hooks run before and after the files are
+// removed. Finally the uninstaller removes itself, and the installation directory if
+// it is empty. If any files couldn't be removed, the uninstaller and its manifest are
+// kept instead, so that it can be run again, and an error is returned.
+func RunUninstall(
+	installerTempPath, manifestPath string, yes bool,
+	translator *Translator, config *Config,
+) error {
+	manifest, err := loadUninstallManifest(manifestPath)
+	if err != nil {
+		slog.Error(
+			"Unable to read uninstall manifest", "path", manifestPath, ErrorAttr(err),
+		)
+		return err
+	}
+	slog.Info("Uninstalling", "product", manifest.Product, "path", manifest.Target)
+	if len(manifest.Language) > 0 {
+		translator.SetLanguage(manifest.Language)
+	}
+	translator.Variables["installDir"] = manifest.Target
+	installer := NewInstallerTo(manifest.Target, installerTempPath, config)
+	if len(manifest.InstallType) > 0 {
+		installer.SetInstallType(manifest.InstallType)
+	}
+	variables := installer.variables(translator.Variables, translator.GetAllStringsRaw())
+
+	modified := manifest.modifiedFiles()
+	if len(modified) > 0 {
+		fmt.Println(translator.Get("uninstall_modified"))
+		for _, file := range manifest.Files {
+			if modified[file.Path] {
+				fmt.Println("  " + file.Path)
+			}
+		}
+	}
+	if !yes && !confirmUninstall(translator) {
+		return ErrAborted
+	}
+
+	err = installer.runHook("pre-uninstall", variables)
+	if err != nil {
+		slog.Error("Hook failed", "hook", "pre-uninstall", ErrorAttr(err))
+		fmt.Println(ErrorMessage(err, translator))
+		return err
+	}
+	failed := manifest.removeFiles(modified)
+	err = installer.runHook("post-uninstall", variables)
+	if err != nil {
+		slog.Error("Hook failed", "hook", "post-uninstall", ErrorAttr(err))
+		fmt.Println(ErrorMessage(err, translator))
+	}
+	if failed > 0 {
+		slog.Error("Unable to remove all files", "failed", failed)
+		fmt.Println(ErrorMessage(errUninstallFailed, translator))
+		return errUninstallFailed
+	}
+	os.Remove(filepath.Join(manifest.Target, installedLogFilename))
+	// left behind by an installation that was interrupted
+	os.RemoveAll(filepath.Join(manifest.Target, backupDirName))
+	os.Remove(manifestPath)
+	if executable, exeErr := os.Executable(); exeErr == nil {
+		os.Remove(executable)
+	}
+	record := &InstallRecord{Product: manifest.Product, Target: manifest.Target}
+	record.remove()
+
+	if os.Remove(manifest.Target) == nil {
+		fmt.Println(translator.Get("uninstall_success"))
+	} else {
+		fmt.Println(translator.Get("uninstall_failure"))
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+package linux_installer
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestManifest writes an installation into a new target and returns its manifest:
+// a directory with the files a, b and c, and a symlink l to a.
+func newTestManifest(t *testing.T) *UninstallManifest {
+	t.Helper()
+	target := t.TempDir()
+	contents := map[string]string{"app/a": "alpha", "app/b": "beta", "app/c": "gamma"}
+	writeTestFiles(t, target, contents)
+	if err := os.Symlink("a", filepath.Join(target, "app", "l")); err != nil {
+		t.Fatal(err)
+	}
+	manifest := &UninstallManifest{Product: "Test", Version: "1.0", Target: target}
+	manifest.Files = append(manifest.Files, ManifestFile{
+		Path: filepath.Join(target, "app"), Dir: true,
+	})
+	for _, name := range []string{"app/a", "app/b", "app/c"} {
+		hash := sha256.Sum256([]byte(contents[name]))
+		manifest.Files = append(manifest.Files, ManifestFile{
+			Path:   filepath.Join(target, name),
+			Size:   int64(len(contents[name])),
+			SHA256: hex.EncodeToString(hash[:]),
+		})
+	}
+	manifest.Files = append(manifest.Files, ManifestFile{
+		Path: filepath.Join(target, "app", "l"), Link: "a", Size: 1,
+	})
+	return manifest
+}
+
+func TestModifiedFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(dir string) error
+		want   []string
+	}{
+		{"unmodified", func(string) error { return nil }, nil},
+		{"changed content", func(dir string) error {
+			return os.WriteFile(filepath.Join(dir, "a"), []byte("alph4"), 0644)
+		}, []string{"a"}},
+		{"changed size", func(dir string) error {
+			return os.WriteFile(filepath.Join(dir, "b"), []byte("beta 2"), 0644)
+		}, []string{"b"}},
+		{"removed", func(dir string) error {
+			return os.Remove(filepath.Join(dir, "c"))
+		}, nil},
+		{"symlink retargeted", func(dir string) error {
+			if err := os.Remove(filepath.Join(dir, "l")); err != nil {
+				return err
+			}
+			return os.Symlink("b", filepath.Join(dir, "l"))
+		}, []string{"l"}},
+		{"symlink replaced by a file", func(dir string) error {
+			if err := os.Remove(filepath.Join(dir, "l")); err != nil {
+				return err
+			}
+			return os.WriteFile(filepath.Join(dir, "l"), []byte("a"), 0644)
+		}, []string{"l"}},
+		{"file replaced by a symlink", func(dir string) error {
+			if err := os.Remove(filepath.Join(dir, "c")); err != nil {
+				return err
+			}
+			return os.Symlink("a", filepath.Join(dir, "c"))
+		}, []string{"c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manifest := newTestManifest(t)
+			dir := filepath.Join(manifest.Target, "app")
+			if err := test.tamper(dir); err != nil {
+				t.Fatal(err)
+			}
+			modified := manifest.modifiedFiles()
+			if len(modified) != len(test.want) {
+				t.Errorf("modified = %v, want %v", modified, test.want)
+			}
+			for _, name := range test.want {
+				if !modified[filepath.Join(dir, name)] {
+					t.Errorf("%s not modified, want it modified", name)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveFiles(t *testing.T) {
+	manifest := newTestManifest(t)
+	dir := filepath.Join(manifest.Target, "app")
+	writeTestFiles(t, dir, map[string]string{"a": "alph4", "user-file.conf": "keep"})
+	if err := os.Remove(filepath.Join(dir, "c")); err != nil {
+		t.Fatal(err)
+	}
+	if failed := manifest.removeFiles(manifest.modifiedFiles()); failed != 0 {
+		t.Errorf("%d files failed to be removed, want 0", failed)
+	}
+	checkInstalledFiles(t, dir, map[string]string{
+		"a": "alph4", "user-file.conf": "keep",
+	})
+	checkNotExist(t, filepath.Join(dir, "b"), filepath.Join(dir, "l"))
+
+	manifest = newTestManifest(t)
+	if failed := manifest.removeFiles(nil); failed != 0 {
+		t.Errorf("%d files failed to be removed, want 0", failed)
+	}
+	checkNotExist(t, filepath.Join(manifest.Target, "app"))
+}
+
+// testExecutable is the content of a fake executable, without appended boxes.
+const testExecutable = "\x7fELF fake executable"
+
+// writeTestExecutable writes a fake executable with the files appended as a zip
+// archive, like "rice append" does, or without an archive if files is nil.
+func writeTestExecutable(t *testing.T, files map[string]string) *os.File {
+	t.Helper()
+	executable := &bytes.Buffer{}
+	executable.WriteString(testExecutable)
+	if files != nil {
+		writer := zip.NewWriter(executable)
+		writer.SetOffset(int64(executable.Len()))
+		for _, name := range []string{
+			"resources/config.yml", "data-compressed/data.tar.zst", "resources/en.yml",
+		} {
+			fileWriter, err := writer.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(fileWriter, files[name]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "installer")
+	if err := os.WriteFile(path, executable.Bytes(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func TestCopyWithoutData(t *testing.T) {
+	files := map[string]string{
+		"resources/config.yml":         "variables: {}",
+		"data-compressed/data.tar.zst": "payload",
+		"resources/en.yml":             "hello: Hello",
+	}
+	executable := writeTestExecutable(t, files)
+	info, err := executable.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := &bytes.Buffer{}
+	if err := copyWithoutData(copied, executable, info.Size()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(copied.Bytes(), []byte(testExecutable)) {
+		t.Error("copy doesn't start with the executable")
+	}
+	appended, err := zip.NewReader(bytes.NewReader(copied.Bytes()), int64(copied.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"resources/config.yml", "resources/en.yml"}
+	if len(appended.File) != len(want) {
+		t.Fatalf("%d appended files, want %d", len(appended.File), len(want))
+	}
+	for f, file := range appended.File {
+		if file.Name != want[f] {
+			t.Errorf("appended file %d = %s, want %s", f, file.Name, want[f])
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || string(content) != files[file.Name] {
+			t.Errorf("%s = %q, %v, want %q", file.Name, content, err, files[file.Name])
+		}
+	}
+	start := appendedStart(bytes.NewReader(copied.Bytes()), appended)
+	if start != int64(len(testExecutable)) {
+		t.Errorf("appended archive starts at %d, want %d", start, len(testExecutable))
+	}
+}
+
+func TestCopyWithoutDataUnappended(t *testing.T) {
+	executable := writeTestExecutable(t, nil)
+	info, err := executable.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := &bytes.Buffer{}
+	if err := copyWithoutData(copied, executable, info.Size()); err != nil {
+		t.Fatal(err)
+	}
+	if copied.String() != testExecutable {
+		t.Errorf("copy = %q, want the executable as it is", copied.String())
+	}
+}
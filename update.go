@@ -0,0 +1,283 @@
+package linux_installer
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// backupDirName is the name of the directory inside the installation target, into which
+// existing files are moved before they are overwritten or removed. This keeps their
+// content, mode and modification time for a rollback.
+const backupDirName = ".installer-backup"
+
+// removedFile is a file or directory of a previous installation which was removed
+// during an update, because it is no longer part of the payload. Removed files are kept
+// at the backup path until the update is finished, in case of a rollback.
+type removedFile struct {
+	path   string
+	backup string
+	dir    bool
+}
+
+// fileMap returns the record's file manifest as a lookup by relative path.
+func (r *InstallRecord) fileMap() map[string]InstallRecordFile {
+	files := make(map[string]InstallRecordFile, len(r.Files))
+	for _, f := range r.Files {
+		files[f.Path] = f
+	}
+	return files
+}
+
+// fileUnchanged returns whether a file is identical in the payload and in the previous
+// installation, and still present on disk with the same size, so that it doesn't need
+// to be copied again. The file is checked in the target, even during a staged
+// installation. The checksum in the payload is compared last, since it may have to be
+// calculated first (see PayloadFile.checksum()).
+func (i *Installer) fileUnchanged(
+	file *InstallFile, previousFiles map[string]InstallRecordFile,
+) bool {
+	previousChecksum, ok := i.previousChecksum(file, previousFiles)
+	if !ok {
+		return false
+	}
+	checksum, err := file.checksum()
+	return err == nil && checksum == previousChecksum
+}
+
+// previousChecksum returns the checksum of a file in the previous installation, if it
+// is still present on disk with the same size as in the payload. Otherwise the file has
+// changed either way.
+func (i *Installer) previousChecksum(
+	file *InstallFile, previousFiles map[string]InstallRecordFile,
+) (uint32, bool) {
+	previousFile, ok := previousFiles[file.Target]
+	if !ok || previousFile.Dir {
+		return 0, false
+	}
+	info, err := os.Lstat(filepath.Join(i.Target, file.Target))
+	if err != nil || !info.Mode().IsRegular() ||
+		info.Size() != int64(file.UncompressedSize64) {
+		return 0, false
+	}
+	return previousFile.CRC32, true
+}
+
+// deferChecksum returns whether the checksum of a file should only be calculated while
+// it is installed, see replaceIfChanged(). That is the case for files of sequential
+// archives whose checksum isn't known yet. Calculating it first reads the file, and
+// installing it afterwards would read the whole archive again from the start (see
+// tarPayload).
+func (i *Installer) deferChecksum(file *InstallFile) bool {
+	return file.sequential() && file.hardLink == nil && !file.checksummed
+}
+
+// existingChecksum returns the checksum of the existing file at a file's target when
+// updating or repairing, if the file's own checksum is deferred (see deferChecksum()),
+// and the existing file may be unchanged. See previousChecksum() and
+// installedChecksum().
+func (i *Installer) existingChecksum(
+	file *InstallFile, updating bool, repairing bool,
+	previousFiles map[string]InstallRecordFile,
+) (uint32, bool) {
+	switch {
+	case !i.deferChecksum(file):
+		return 0, false
+	case updating:
+		return i.previousChecksum(file, previousFiles)
+	case repairing:
+		return i.installedChecksum(file)
+	}
+	return 0, false
+}
+
+// replaceIfChanged installs a file whose checksum isn't known yet (see deferChecksum())
+// over an existing file with the given checksum, unless both are the same. The file is
+// written next to its target (see writePartial()), which calculates its checksum, and
+// only replaces the existing file if the checksums differ. Otherwise the partial file
+// is removed again, and the existing file is kept, or linked into the staging
+// directory. Returns whether the file was unchanged.
+func (i *Installer) replaceIfChanged(file *InstallFile, checksum uint32) (bool, error) {
+	fileReader, err := file.Open()
+	if err != nil {
+		return false, err
+	}
+	partial, err := i.writePartial(file, fileReader)
+	fileReader.Close()
+	if err != nil {
+		return false, err
+	}
+	if file.CRC32 == checksum && (len(i.staging) == 0 || i.linkUnchanged(file)) {
+		i.discardPartial(file, partial)
+		return true, nil
+	}
+	if len(i.staging) == 0 {
+		err = i.backupFile(file)
+	}
+	if err == nil {
+		i.journalFile(file, journalStarted)
+		err = os.Rename(partial, i.fileTarget(file))
+	}
+	if err != nil {
+		i.discardPartial(file, partial)
+	}
+	return false, err
+}
+
+// backupDir returns the directory for backed up files.
+func (i *Installer) backupDir() string {
+	return filepath.Join(i.Target, backupDirName)
+}
+
+// moveToBackup moves a file from the installation target into the backup directory and
+// returns the backup path.
+func (i *Installer) moveToBackup(relPath string) (string, error) {
+	backupPath := filepath.Join(i.backupDir(), relPath)
+	err := os.MkdirAll(filepath.Dir(backupPath), 0755)
+	if err != nil {
+		return "", err
+	}
+	return backupPath, os.Rename(filepath.Join(i.Target, relPath), backupPath)
+}
+
+// backupFile moves an existing file at the target location of the given file into the
+// backup directory, so that it can be restored in case of a rollback. This is the case
+// for files of a previous installation, but also for any other file in the target that
+// happens to have the same name. If there is no file, nothing happens.
+func (i *Installer) backupFile(file *InstallFile) error {
+	if _, err := os.Lstat(i.fileTarget(file)); os.IsNotExist(err) {
+		return nil
+	}
+	backupPath, err := i.moveToBackup(file.Target)
+	if err != nil {
+		return err
+	}
+	file.backup = backupPath
+	return nil
+}
+
+// restoreBackup moves the backed up original of a file back to its target location.
+func (i *Installer) restoreBackup(file *InstallFile) {
+	if file.backup == "" {
+		return
+	}
+	err := os.Rename(file.backup, i.fileTarget(file))
+	if err != nil {
+		slog.Warn(
+			"Unable to restore file", "phase", PhaseRolledBack,
+			"file", i.fileTarget(file), ErrorAttr(err),
+		)
+	} else {
+		slog.Debug(
+			"Restored file", "phase", PhaseRolledBack, "file", i.fileTarget(file),
+		)
+	}
+	file.backup = ""
+}
+
+// removeObsoleteFiles removes all files and directories of the previous installation
+// which are not part of the current installation. Files are moved into the backup
+// directory, directories are only removed if they are empty, so that files created by
+// the user are kept.
+func (i *Installer) removeObsoleteFiles(previousFiles map[string]InstallRecordFile) {
+	current := make(map[string]bool, len(i.files))
+	for _, file := range i.files {
+		current[file.Target] = true
+	}
+	// reversed -> remove dir content before dir
+	for p := len(i.previous.Files) - 1; p >= 0; p-- {
+		previousFile := i.previous.Files[p]
+		if current[previousFile.Path] {
+			continue
+		}
+		removed := &removedFile{
+			path: filepath.Join(i.Target, previousFile.Path),
+			dir:  previousFile.Dir,
+		}
+		var err error
+		if previousFile.Dir {
+			err = os.Remove(removed.path)
+		} else if _, err = os.Lstat(removed.path); err == nil {
+			removed.backup, err = i.moveToBackup(previousFile.Path)
+		}
+		if err != nil {
+			slog.Warn("Keeping obsolete file", "file", removed.path, ErrorAttr(err))
+			continue
+		}
+		slog.Debug("Removed obsolete file", "file", removed.path)
+		i.removedFiles = append(i.removedFiles, removed)
+	}
+}
+
+// restoreRemovedFiles restores all files and directories removed by
+// removeObsoleteFiles.
+func (i *Installer) restoreRemovedFiles() {
+	for p := len(i.removedFiles) - 1; p >= 0; p-- {
+		removed := i.removedFiles[p]
+		var err error
+		if removed.dir {
+			err = os.MkdirAll(removed.path, 0755)
+		} else {
+			os.MkdirAll(filepath.Dir(removed.path), 0755)
+			err = os.Rename(removed.backup, removed.path)
+		}
+		if err != nil {
+			slog.Warn(
+				"Unable to restore file", "phase", PhaseRolledBack,
+				"file", removed.path, ErrorAttr(err),
+			)
+		} else {
+			slog.Debug("Restored file", "phase", PhaseRolledBack, "file", removed.path)
+		}
+	}
+	i.removedFiles = nil
+}
+
+// discardBackup deletes the backup directory once it is no longer needed, i.e. after
+// PostInstall() succeeded.
+func (i *Installer) discardBackup() {
+	for _, file := range i.files {
+		file.backup = ""
+	}
+	i.removedFiles = nil
+	os.RemoveAll(i.backupDir())
+}
+
+// backupUninstaller moves the uninstaller and uninstall manifest of a previous
+// installation in the target into the backup directory, before PostInstall() replaces
+// them, so that a rollback restores them like removed files.
+func (i *Installer) backupUninstaller(variables VariableMap) {
+	for _, relPath := range []string{
+		variables["uninstaller_name"], uninstallManifestFilename,
+	} {
+		path := filepath.Join(i.Target, relPath)
+		if _, err := os.Lstat(path); err != nil {
+			continue
+		}
+		backupPath, err := i.moveToBackup(relPath)
+		if err != nil {
+			slog.Warn("Unable to back up file", "file", path, ErrorAttr(err))
+			continue
+		}
+		i.removedFiles = append(
+			i.removedFiles, &removedFile{path: path, backup: backupPath},
+		)
+	}
+}
+
+// removePostInstallFiles removes the files that PostInstall() created, when the
+// installation is rolled back.
+func (i *Installer) removePostInstallFiles() {
+	for _, path := range i.postInstallFiles {
+		err := os.Remove(path)
+		if err == nil {
+			slog.Debug("Rolled back file", "phase", PhaseRolledBack, "file", path)
+		} else if !os.IsNotExist(err) {
+			slog.Warn(
+				"Unable to roll back file", "phase", PhaseRolledBack, "file", path,
+				ErrorAttr(err),
+			)
+		}
+	}
+	i.postInstallFiles = nil
+}
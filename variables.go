@@ -2,8 +2,9 @@ package linux_installer
 
 import (
 	"bytes"
-	"fmt"
-	"log"
+	"io/ioutil"
+	"log/slog"
+	"os"
 	"strings"
 	"text/template"
 )
@@ -56,13 +57,13 @@ func expandAllVariablesRecursively(
 	}
 	templ, err := template.New("").Funcs(varTemplateFunctions).Parse(str)
 	if err != nil {
-		log.Println(fmt.Sprintf("Invalid string template: '%s'", err))
+		slog.Error("Invalid string template", ErrorAttr(err))
 		return str
 	}
 	var buf bytes.Buffer
 	err = templ.Execute(&buf, untypedVariables)
 	if err != nil {
-		log.Println(fmt.Sprintf("Error executing template: '%s'", err))
+		slog.Error("Unable to execute template", ErrorAttr(err))
 		return str
 	}
 	expanded = buf.String()
@@ -74,6 +75,21 @@ func expandAllVariablesRecursively(
 	return
 }
 
+// ExpandFileVariables expands template variables in the file at the given path, and
+// replaces the file's content with the result.
+func ExpandFileVariables(path string, variables VariableMap) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	expanded := ExpandVariables(string(content), variables)
+	return ioutil.WriteFile(path, []byte(expanded), info.Mode())
+}
+
 // MergeVariables combines several variable maps into a single one. Duplicate keys will
 // be overridden by the value in the last map which has the key.
 func MergeVariables(varMaps ...VariableMap) VariableMap {
@@ -0,0 +1,172 @@
+package linux_installer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// verifyFiles checks all files written during the installation against the payload
+// (see checkFile()), reporting each file as PhaseVerify. Files of a staged installation
+// are checked in the staging directory. Returns ErrAborted if ctx is canceled
+// meanwhile.
+func (i *Installer) verifyFiles(ctx context.Context) error {
+	for _, file := range i.files {
+		if !file.installed {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ErrAborted
+		default:
+		}
+		i.setStatus(InstallStatus{S: file.Name, File: file, Phase: PhaseVerify})
+		err := i.checkFile(file)
+		if err != nil {
+			slog.Error(
+				"Verification failed", "phase", PhaseVerify,
+				"file", i.fileTarget(file), ErrorAttr(err),
+			)
+			return ErrVerifyFailed.wrap(err)
+		}
+	}
+	slog.Info("Verified installed files", "phase", PhaseVerify)
+	return nil
+}
+
+// checkFile checks a file on disk against the payload. Directories must exist, symlinks
+// must have the link target from the payload, and regular files the size and CRC32
+// checksum from the payload, as well as the SHA-256 hash they were written with, if
+// known. Otherwise the hash is set on the file.
+func (i *Installer) checkFile(file *InstallFile) error {
+	path := i.fileTarget(file)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if file.IsDir() {
+		if !info.IsDir() {
+			return errFileDiffers(file)
+		}
+		return nil
+	}
+	if file.symlink() {
+		linkTarget := file.link
+		if len(linkTarget) == 0 {
+			if linkTarget, err = file.symlinkTarget(); err != nil {
+				return err
+			}
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return errFileDiffers(file)
+		}
+		if actual, err := os.Readlink(path); err != nil {
+			return err
+		} else if actual != linkTarget {
+			return errFileDiffers(file)
+		}
+		file.link = linkTarget
+		return nil
+	}
+	if !info.Mode().IsRegular() || info.Size() != int64(file.UncompressedSize64) {
+		return errFileDiffers(file)
+	}
+	checksum, err := file.checksum()
+	if err != nil {
+		return err
+	}
+	installedFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer installedFile.Close()
+	crcHash := crc32.NewIEEE()
+	shaHash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(crcHash, shaHash), installedFile)
+	if err != nil {
+		return err
+	}
+	sha := hex.EncodeToString(shaHash.Sum(nil))
+	if crcHash.Sum32() != checksum || len(file.sha256) > 0 && sha != file.sha256 {
+		return errFileDiffers(file)
+	}
+	file.sha256 = sha
+	return nil
+}
+
+// installedChecksum returns the CRC32 checksum of a file of the installation that is
+// repaired, if it is a regular file with the same size as in the payload. Otherwise it
+// has to be repaired either way.
+func (i *Installer) installedChecksum(file *InstallFile) (uint32, bool) {
+	path := i.fileTarget(file)
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() ||
+		info.Size() != int64(file.UncompressedSize64) {
+		return 0, false
+	}
+	installedFile, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer installedFile.Close()
+	crcHash := crc32.NewIEEE()
+	if _, err = io.Copy(crcHash, installedFile); err != nil {
+		return 0, false
+	}
+	return crcHash.Sum32(), true
+}
+
+// errFileDiffers returns the detail for ErrVerifyFailed if a file doesn't match the
+// payload.
+func errFileDiffers(file *InstallFile) error {
+	return fmt.Errorf("%s differs from the payload", file.Target)
+}
+
+// Repairing returns whether the installer will repair an installation, i.e. if Repair
+// is set and the target is the directory of an installation of the same product.
+func (i *Installer) Repairing() bool {
+	return i.Repair && i.repairRecord() != nil
+}
+
+// repairRecord returns the install record of the product's installation in the
+// target, or nil if there is none.
+func (i *Installer) repairRecord() *InstallRecord {
+	for _, record := range loadInstallRecords(i.config.Variables["product"]) {
+		if filepath.Clean(record.Target) == i.Target {
+			return record
+		}
+	}
+	return nil
+}
+
+// selectRecordedFiles restricts the files to install to those recorded for the
+// installation that is repaired, regardless of the selected components.
+func (i *Installer) selectRecordedFiles(recordFiles map[string]InstallRecordFile) {
+	i.files = make([]*InstallFile, 0, len(recordFiles))
+	i.totalSize = 0
+	for _, file := range i.allFiles {
+		if _, ok := recordFiles[file.Target]; !ok {
+			continue
+		}
+		i.files = append(i.files, file)
+		if !file.IsDir() {
+			i.totalSize += int64(file.UncompressedSize64)
+		}
+	}
+}
+
+// fileIntact returns whether a file of the installation that is repaired is still
+// present and matches the payload, so that it doesn't need to be written again.
+func (i *Installer) fileIntact(file *InstallFile) bool {
+	err := i.checkFile(file)
+	if err != nil {
+		slog.Info("Repairing file", "file", i.fileTarget(file), ErrorAttr(err))
+	}
+	return err == nil
+}
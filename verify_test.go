@@ -0,0 +1,120 @@
+package linux_installer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// installTestPayload installs the test entries from a tar.zst payload into a new
+// target, and returns the target and the installer.
+func installTestPayload(t *testing.T) (string, *Installer) {
+	t.Helper()
+	target := t.TempDir()
+	payload, _ := newTestTarPayload(t, "tar.zst", testEntries)
+	installer := newPayloadInstaller(t, target, payload)
+	err := installer.installFiles(context.Background(), false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return target, installer
+}
+
+func TestCheckFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   int
+		tamper func(path string) error
+	}{
+		{"intact", 1, nil},
+		{"changed content", 1, func(path string) error {
+			return os.WriteFile(path, []byte("alph4"), 0644)
+		}},
+		{"truncated", 4, func(path string) error { return os.Truncate(path, 2) }},
+		{"removed", 5, os.Remove},
+		{"symlink retargeted", 2, func(path string) error {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			return os.Symlink("b", path)
+		}},
+		{"symlink replaced by a file", 2, func(path string) error {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			return os.WriteFile(path, []byte("a"), 0644)
+		}},
+		{"directory replaced by a file", 0, func(path string) error {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			return os.WriteFile(path, nil, 0644)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, installer := installTestPayload(t)
+			file := installer.files[test.file]
+			if test.tamper != nil {
+				if err := test.tamper(installer.fileTarget(file)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := installer.checkFile(file)
+			if test.tamper == nil && err != nil {
+				t.Errorf("checkFile(%s) = %v, want nil", file.Target, err)
+			} else if test.tamper != nil && err == nil {
+				t.Errorf("checkFile(%s) = nil, want an error", file.Target)
+			}
+			err = installer.verifyFiles(context.Background())
+			if test.tamper == nil && err != nil {
+				t.Errorf("verifyFiles() = %v, want nil", err)
+			} else if test.tamper != nil && !errors.Is(err, ErrVerifyFailed) {
+				t.Errorf("verifyFiles() = %v, want ErrVerifyFailed", err)
+			}
+		})
+	}
+}
+
+func TestRepairTarPayload(t *testing.T) {
+	target, _ := installTestPayload(t)
+	changed := filepath.Join(target, "app", "b")
+	if err := os.WriteFile(changed, []byte("bet4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(target, "app", "c")); err != nil {
+		t.Fatal(err)
+	}
+	payload, passes := newTestTarPayload(t, "tar.zst", testEntries)
+	installer := newPayloadInstaller(t, target, payload)
+	err := installer.installFiles(context.Background(), false, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unchanged := map[string]bool{}
+	for _, file := range installer.files {
+		unchanged[file.Target] = file.unchanged
+	}
+	want := map[string]bool{
+		"app/": true, "app/a": true, "app/l": true, "app/h": true,
+		"app/b": false, "app/c": false,
+	}
+	for name, wantUnchanged := range want {
+		if unchanged[name] != wantUnchanged {
+			t.Errorf(
+				"%s unchanged = %v, want %v", name, unchanged[name], wantUnchanged,
+			)
+		}
+	}
+	checkInstalledFiles(t, target, map[string]string{
+		"app/a": "alpha", "app/h": "alpha", "app/b": "beta", "app/c": "gamma",
+	})
+	if err := installer.verifyFiles(context.Background()); err != nil {
+		t.Errorf("verifyFiles() = %v, want nil", err)
+	}
+	if *passes != 1 {
+		t.Errorf("%d passes through the archive, want 1", *passes)
+	}
+}